@@ -0,0 +1,75 @@
+package coc
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/MrNemo64/coc-tracker/util"
+)
+
+func (c *Client) GetClan(ctx context.Context, tag string) (*Clan, error) {
+	return get[Clan](c, ctx, tagPath(util.ClanEndpoint, tag))
+}
+
+func (c *Client) SearchClans(ctx context.Context, search ClanSearch, opts ListOptions) (*List[Clan], error) {
+	return getList[Clan](c, ctx, util.ClanEndpoint, search.values(), opts)
+}
+
+func (s ClanSearch) values() url.Values {
+	values := url.Values{}
+	if s.Name != "" {
+		values.Set("name", s.Name)
+	}
+	if s.WarFrequency != "" {
+		values.Set("warFrequency", s.WarFrequency)
+	}
+	for name, value := range map[string]int{
+		"locationId":    s.LocationId,
+		"minMembers":    s.MinMembers,
+		"maxMembers":    s.MaxMembers,
+		"minClanPoints": s.MinClanPoints,
+		"minClanLevel":  s.MinClanLevel,
+	} {
+		if value > 0 {
+			values.Set(name, strconv.Itoa(value))
+		}
+	}
+	if len(s.LabelIds) > 0 {
+		ids := make([]string, len(s.LabelIds))
+		for i, id := range s.LabelIds {
+			ids[i] = strconv.Itoa(id)
+		}
+		values.Set("labelIds", strings.Join(ids, ","))
+	}
+	return values
+}
+
+func (c *Client) GetClanMembers(ctx context.Context, tag string, opts ListOptions) (*List[ClanMember], error) {
+	return getList[ClanMember](c, ctx, tagPath(util.ClanEndpoint, tag, "members"), nil, opts)
+}
+
+// GetCurrentWar returns the regular war the clan is in. The state is notInWar
+// when there is none, and the war log has to be public to see it.
+func (c *Client) GetCurrentWar(ctx context.Context, tag string) (*ClanWar, error) {
+	return get[ClanWar](c, ctx, tagPath(util.ClanEndpoint, tag, "currentwar"))
+}
+
+func (c *Client) GetWarLog(ctx context.Context, tag string, opts ListOptions) (*List[ClanWarLogEntry], error) {
+	return getList[ClanWarLogEntry](c, ctx, tagPath(util.ClanEndpoint, tag, "warlog"), nil, opts)
+}
+
+// GetLeagueGroup returns the clan war league group the clan is in this season.
+func (c *Client) GetLeagueGroup(ctx context.Context, tag string) (*ClanWarLeagueGroup, error) {
+	return get[ClanWarLeagueGroup](c, ctx, tagPath(util.ClanEndpoint, tag, "currentwar", "leaguegroup"))
+}
+
+// GetLeagueWar returns one of the wars listed in the rounds of a league group.
+func (c *Client) GetLeagueWar(ctx context.Context, warTag string) (*ClanWar, error) {
+	return get[ClanWar](c, ctx, tagPath(util.ClanWarLeagueEndpoint+"/wars", warTag))
+}
+
+func (c *Client) GetCapitalRaidSeasons(ctx context.Context, tag string, opts ListOptions) (*List[ClanCapitalRaidSeason], error) {
+	return getList[ClanCapitalRaidSeason](c, ctx, tagPath(util.ClanEndpoint, tag, "capitalraidseasons"), nil, opts)
+}
@@ -0,0 +1,162 @@
+package coc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Requester sends authenticated GET requests to the API, the url being relative
+// to util.BaseUrl. Both the tracker client and the context given to jobs are
+// requesters.
+type Requester interface {
+	Get(ctx context.Context, url string) (response *http.Response, cacheHit bool, err error)
+}
+
+// Poster is implemented by requesters that can also send POST requests, which
+// are only needed by the endpoints that don't just read data.
+type Poster interface {
+	Post(ctx context.Context, url string, body io.Reader) (*http.Response, error)
+}
+
+var ErrPostNotSupported = errors.New("requester can not send POST requests")
+
+// Client exposes the endpoints of the Clash of Clans API with typed responses.
+type Client struct {
+	requester Requester
+}
+
+func NewClient(requester Requester) *Client {
+	return &Client{requester: requester}
+}
+
+// ListOptions selects a page of a list endpoint. Zero values are left out of
+// the request so the API defaults apply.
+type ListOptions struct {
+	Limit  int
+	After  string
+	Before string
+}
+
+func (o ListOptions) values() url.Values {
+	values := url.Values{}
+	if o.Limit > 0 {
+		values.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.After != "" {
+		values.Set("after", o.After)
+	}
+	if o.Before != "" {
+		values.Set("before", o.Before)
+	}
+	return values
+}
+
+// List is a page of the items returned by a list endpoint.
+type List[T any] struct {
+	Items  []T    `json:"items"`
+	Paging Paging `json:"paging"`
+	// Whether the page was served from the cache of the requester, so jobs can
+	// skip writing data they already stored
+	CacheHit bool `json:"-"`
+}
+
+type Paging struct {
+	Cursors Cursors `json:"cursors"`
+}
+
+type Cursors struct {
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// NormalizeTag returns a player or clan tag the way the API expects it,
+// upper case and starting with #.
+func NormalizeTag(tag string) string {
+	tag = strings.ToUpper(strings.TrimSpace(tag))
+	if !strings.HasPrefix(tag, "#") {
+		tag = "#" + tag
+	}
+	return tag
+}
+
+// tagPath joins an endpoint with a tag, escaping the # of the tag.
+func tagPath(endpoint string, tag string, rest ...string) string {
+	path := endpoint + "/" + url.PathEscape(NormalizeTag(tag))
+	for _, segment := range rest {
+		path += "/" + segment
+	}
+	return path
+}
+
+func withQuery(path string, values url.Values) string {
+	if len(values) == 0 {
+		return path
+	}
+	return path + "?" + values.Encode()
+}
+
+func get[T any](c *Client, ctx context.Context, path string) (*T, error) {
+	response, _, err := c.requester.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return decode[T](response, path)
+}
+
+func getList[T any](c *Client, ctx context.Context, path string, values url.Values, opts ListOptions) (*List[T], error) {
+	if values == nil {
+		values = url.Values{}
+	}
+	for key, value := range opts.values() {
+		values[key] = value
+	}
+	path = withQuery(path, values)
+	response, cacheHit, err := c.requester.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	list, err := decode[List[T]](response, path)
+	if err != nil {
+		return nil, err
+	}
+	list.CacheHit = cacheHit
+	return list, nil
+}
+
+func post[T any](c *Client, ctx context.Context, path string, body any) (*T, error) {
+	poster, ok := c.requester.(Poster)
+	if !ok {
+		return nil, ErrPostNotSupported
+	}
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	response, err := poster.Post(ctx, path, bytes.NewReader(encoded))
+	if err != nil {
+		return nil, err
+	}
+	return decode[T](response, path)
+}
+
+func decode[T any](response *http.Response, path string) (*T, error) {
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		apiErr := ReadAPIError(response)
+		apiErr.Path = path
+		return nil, apiErr
+	}
+	var decoded T
+	if err := json.NewDecoder(response.Body).Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", path, err)
+	}
+	return &decoded, nil
+}
@@ -0,0 +1,155 @@
+package coc_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MrNemo64/coc-tracker/coc"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeResponse struct {
+	status int
+	body   string
+}
+
+// fakeRequester answers the requests with canned responses by url, recording
+// the urls and bodies it was sent.
+type fakeRequester struct {
+	responses map[string]fakeResponse
+	// Urls answered as if they came from the cache
+	cached    map[string]bool
+	requested []string
+	posted    []string
+}
+
+func (f *fakeRequester) Get(_ context.Context, url string) (*http.Response, bool, error) {
+	f.requested = append(f.requested, url)
+	return f.respond(url), f.cached[url], nil
+}
+
+func (f *fakeRequester) respond(url string) *http.Response {
+	response, ok := f.responses[url]
+	if !ok {
+		response = fakeResponse{http.StatusNotFound, `{"reason": "notFound"}`}
+	}
+	return &http.Response{
+		Status:     http.StatusText(response.status),
+		StatusCode: response.status,
+		Body:       io.NopCloser(strings.NewReader(response.body)),
+	}
+}
+
+type fakePoster struct {
+	fakeRequester
+}
+
+func (f *fakePoster) Post(_ context.Context, url string, body io.Reader) (*http.Response, error) {
+	sent, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	f.requested = append(f.requested, url)
+	f.posted = append(f.posted, string(sent))
+	return f.respond(url), nil
+}
+
+func TestGetClan(t *testing.T) {
+	t.Parallel()
+
+	requester := &fakeRequester{responses: map[string]fakeResponse{
+		"/clans/%232PP": {http.StatusOK, `{
+			"tag": "#2PP",
+			"name": "Clan",
+			"clanLevel": 10,
+			"warLeague": {"id": 48000010, "name": "Crystal League I"},
+			"memberList": [{"tag": "#P1", "name": "Member", "role": "leader", "league": {"id": 29000022, "name": "Legend League", "iconUrls": {"small": "s"}}}]
+		}`},
+	}}
+	client := coc.NewClient(requester)
+
+	clan, err := client.GetClan(context.Background(), " 2pp")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "#2PP", clan.Tag)
+		assert.Equal(t, 10, clan.ClanLevel)
+		assert.Equal(t, &coc.League{Id: 48000010, Name: "Crystal League I"}, clan.WarLeague)
+		if assert.Len(t, clan.MemberList, 1) {
+			assert.Equal(t, "s", clan.MemberList[0].League.IconUrls.Small)
+		}
+	}
+
+	_, err = client.GetClan(context.Background(), "#OTHER")
+	assert.Error(t, err)
+	assert.Equal(t, []string{"/clans/%232PP", "/clans/%23OTHER"}, requester.requested)
+}
+
+func TestListEndpoints(t *testing.T) {
+	t.Parallel()
+
+	requester := &fakeRequester{responses: map[string]fakeResponse{
+		"/clans?labelIds=56000000%2C56000001&limit=2&minMembers=10&name=abc": {http.StatusOK, `{
+			"items": [{"tag": "#A"}, {"tag": "#B"}],
+			"paging": {"cursors": {"after": "next"}}
+		}`},
+		"/locations/32000006/rankings/players?after=next": {http.StatusOK, `{"items": [{"tag": "#P", "rank": 3}], "paging": {"cursors": {}}}`},
+	}}
+	client := coc.NewClient(requester)
+
+	clans, err := client.SearchClans(context.Background(), coc.ClanSearch{
+		Name:       "abc",
+		MinMembers: 10,
+		LabelIds:   []int{56000000, 56000001},
+	}, coc.ListOptions{Limit: 2})
+	if assert.NoError(t, err) {
+		assert.Len(t, clans.Items, 2)
+		assert.Equal(t, "next", clans.Paging.Cursors.After)
+	}
+
+	rankings, err := client.GetPlayerRankings(context.Background(), 32000006, coc.ListOptions{After: "next"})
+	if assert.NoError(t, err) && assert.Len(t, rankings.Items, 1) {
+		assert.Equal(t, 3, rankings.Items[0].Rank)
+		assert.Empty(t, rankings.Paging.Cursors.After)
+	}
+}
+
+func TestTimes(t *testing.T) {
+	t.Parallel()
+
+	requester := &fakeRequester{responses: map[string]fakeResponse{
+		"/clans/%23A/currentwar":    {http.StatusOK, `{"state": "inWar", "startTime": "20240115T080000.000Z", "clan": {"tag": "#A"}}`},
+		"/goldpass/seasons/current": {http.StatusOK, `{"startTime": "20240201T080000.000Z", "endTime": "20240301T080000.000Z"}`},
+	}}
+	client := coc.NewClient(requester)
+
+	war, err := client.GetCurrentWar(context.Background(), "#A")
+	if assert.NoError(t, err) {
+		assert.Equal(t, time.Date(2024, 1, 15, 8, 0, 0, 0, time.UTC), war.StartTime.Time)
+		assert.True(t, war.EndTime.IsZero())
+	}
+
+	season, err := client.GetGoldPassSeason(context.Background())
+	if assert.NoError(t, err) {
+		assert.Equal(t, time.February, season.StartTime.Month())
+		assert.Equal(t, time.March, season.EndTime.Month())
+	}
+}
+
+func TestVerifyPlayerToken(t *testing.T) {
+	t.Parallel()
+
+	_, err := coc.NewClient(&fakeRequester{}).VerifyPlayerToken(context.Background(), "#P", "token")
+	assert.ErrorIs(t, err, coc.ErrPostNotSupported)
+
+	poster := &fakePoster{fakeRequester{responses: map[string]fakeResponse{
+		"/players/%23P/verifytoken": {http.StatusOK, `{"tag": "#P", "token": "token", "status": "ok"}`},
+	}}}
+	verified, err := coc.NewClient(poster).VerifyPlayerToken(context.Background(), "#P", "token")
+	if assert.NoError(t, err) {
+		assert.True(t, verified.Valid())
+	}
+	assert.Equal(t, []string{`{"token":"token"}`}, poster.posted)
+}
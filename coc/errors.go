@@ -0,0 +1,95 @@
+package coc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Reasons sent by the API in the body of error responses
+const (
+	ReasonBadRequest   = "badRequest"
+	ReasonAccessDenied = "accessDenied"
+	ReasonInvalidIp    = "accessDenied.invalidIp"
+	ReasonNotFound     = "notFound"
+	ReasonThrottled    = "requestThrottled"
+	ReasonUnknown      = "unknownException"
+	ReasonMaintenance  = "inMaintenance"
+)
+
+// Errors matched by APIError with errors.Is
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrAccessDenied = errors.New("access denied")
+	ErrInvalidIp    = errors.New("api key not allowed from this ip")
+	ErrThrottled    = errors.New("request throttled")
+	ErrMaintenance  = errors.New("api in maintenance")
+)
+
+// Error bodies are small, anything bigger is not one
+const maxErrorBodySize = 64 << 10
+
+// APIError is returned for every response of the API that is not successful.
+type APIError struct {
+	// Relative to util.BaseUrl, empty if the error was not built by the client
+	Path       string `json:"-"`
+	StatusCode int    `json:"-"`
+	Reason     string `json:"reason"`
+	Message    string `json:"message"`
+	// From the Retry-After header, zero if it was not sent
+	RetryAfter time.Duration `json:"-"`
+}
+
+func (e *APIError) Error() string {
+	message := fmt.Sprintf("api responded with status %d", e.StatusCode)
+	if e.Reason != "" {
+		message += " (" + e.Reason + ")"
+	}
+	if e.Path != "" {
+		message += " requesting " + e.Path
+	}
+	if e.Message != "" {
+		message += ": " + e.Message
+	}
+	return message
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrAccessDenied:
+		return e.StatusCode == http.StatusForbidden
+	case ErrInvalidIp:
+		return e.StatusCode == http.StatusForbidden && e.Reason == ReasonInvalidIp
+	case ErrThrottled:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrMaintenance:
+		return e.StatusCode == http.StatusServiceUnavailable
+	}
+	return false
+}
+
+// ReadAPIError builds the error of an unsuccessful response. The body is
+// buffered so it can still be read afterwards.
+func ReadAPIError(response *http.Response) *APIError {
+	apiErr := &APIError{StatusCode: response.StatusCode}
+	if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxErrorBodySize))
+	response.Body.Close()
+	response.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil || len(body) == 0 {
+		return apiErr
+	}
+	// Bodies that are not JSON, like the ones of proxies, only give the status
+	json.Unmarshal(body, apiErr)
+	return apiErr
+}
@@ -0,0 +1,69 @@
+package coc_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MrNemo64/coc-tracker/coc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadAPIError(t *testing.T) {
+	t.Parallel()
+
+	header := make(http.Header)
+	header.Set("Retry-After", "30")
+	response := &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(`{"reason": "requestThrottled", "message": "Slow down"}`)),
+	}
+	apiErr := coc.ReadAPIError(response)
+	assert.Equal(t, &coc.APIError{
+		StatusCode: http.StatusTooManyRequests,
+		Reason:     coc.ReasonThrottled,
+		Message:    "Slow down",
+		RetryAfter: 30 * time.Second,
+	}, apiErr)
+	assert.ErrorIs(t, apiErr, coc.ErrThrottled)
+	assert.NotErrorIs(t, apiErr, coc.ErrNotFound)
+
+	body, err := io.ReadAll(response.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), "Slow down", "The body can be read again")
+
+	apiErr = coc.ReadAPIError(&http.Response{
+		StatusCode: http.StatusServiceUnavailable,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader("<html>Bad gateway</html>")),
+	})
+	assert.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+	assert.Empty(t, apiErr.Reason)
+	assert.ErrorIs(t, apiErr, coc.ErrMaintenance)
+}
+
+func TestClientReturnsAPIErrors(t *testing.T) {
+	t.Parallel()
+
+	client := coc.NewClient(&fakeRequester{responses: map[string]fakeResponse{
+		"/clans/%23BANNED": {http.StatusForbidden, `{"reason": "accessDenied.invalidIp", "message": "Invalid authorization: API key does not allow access from IP 1.2.3.4"}`},
+	}})
+
+	_, err := client.GetPlayer(context.Background(), "#GONE")
+	assert.ErrorIs(t, err, coc.ErrNotFound)
+	var apiErr *coc.APIError
+	if assert.True(t, errors.As(err, &apiErr)) {
+		assert.Equal(t, "/players/%23GONE", apiErr.Path)
+		assert.Equal(t, coc.ReasonNotFound, apiErr.Reason)
+	}
+
+	_, err = client.GetClan(context.Background(), "#BANNED")
+	assert.ErrorIs(t, err, coc.ErrInvalidIp)
+	assert.ErrorIs(t, err, coc.ErrAccessDenied)
+	assert.EqualError(t, err, "api responded with status 403 (accessDenied.invalidIp) requesting /clans/%23BANNED: Invalid authorization: API key does not allow access from IP 1.2.3.4")
+}
@@ -0,0 +1,19 @@
+package coc
+
+import (
+	"context"
+
+	"github.com/MrNemo64/coc-tracker/util"
+)
+
+func (c *Client) GetClanLabels(ctx context.Context, opts ListOptions) (*List[Label], error) {
+	return getList[Label](c, ctx, util.LabelEndpoint+"/clans", nil, opts)
+}
+
+func (c *Client) GetPlayerLabels(ctx context.Context, opts ListOptions) (*List[Label], error) {
+	return getList[Label](c, ctx, util.LabelEndpoint+"/players", nil, opts)
+}
+
+func (c *Client) GetGoldPassSeason(ctx context.Context) (*GoldPassSeason, error) {
+	return get[GoldPassSeason](c, ctx, util.GoldpassEndpoint)
+}
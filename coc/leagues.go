@@ -0,0 +1,57 @@
+package coc
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/MrNemo64/coc-tracker/util"
+)
+
+func (c *Client) GetLeagues(ctx context.Context, opts ListOptions) (*List[League], error) {
+	return getList[League](c, ctx, util.PlayerLeagueEndpoint, nil, opts)
+}
+
+func (c *Client) GetLeague(ctx context.Context, id int) (*League, error) {
+	return get[League](c, ctx, idPath(util.PlayerLeagueEndpoint, id))
+}
+
+// GetLeagueSeasons lists the seasons of a league, only available for the
+// legend league.
+func (c *Client) GetLeagueSeasons(ctx context.Context, leagueId int, opts ListOptions) (*List[LeagueSeason], error) {
+	return getList[LeagueSeason](c, ctx, idPath(util.PlayerLeagueEndpoint, leagueId)+"/seasons", nil, opts)
+}
+
+// GetLeagueSeasonRankings returns the final ranking of a legend league season.
+func (c *Client) GetLeagueSeasonRankings(ctx context.Context, leagueId int, seasonId string, opts ListOptions) (*List[PlayerRanking], error) {
+	path := idPath(util.PlayerLeagueEndpoint, leagueId) + "/seasons/" + url.PathEscape(seasonId)
+	return getList[PlayerRanking](c, ctx, path, nil, opts)
+}
+
+func (c *Client) GetCapitalLeagues(ctx context.Context, opts ListOptions) (*List[League], error) {
+	return getList[League](c, ctx, util.CapitalLeagueEndpoint, nil, opts)
+}
+
+func (c *Client) GetCapitalLeague(ctx context.Context, id int) (*League, error) {
+	return get[League](c, ctx, idPath(util.CapitalLeagueEndpoint, id))
+}
+
+func (c *Client) GetBuilderBaseLeagues(ctx context.Context, opts ListOptions) (*List[League], error) {
+	return getList[League](c, ctx, util.BuilderBaseLeagueEndpoint, nil, opts)
+}
+
+func (c *Client) GetBuilderBaseLeague(ctx context.Context, id int) (*League, error) {
+	return get[League](c, ctx, idPath(util.BuilderBaseLeagueEndpoint, id))
+}
+
+func (c *Client) GetWarLeagues(ctx context.Context, opts ListOptions) (*List[League], error) {
+	return getList[League](c, ctx, util.WarLeagueEndpoint, nil, opts)
+}
+
+func (c *Client) GetWarLeague(ctx context.Context, id int) (*League, error) {
+	return get[League](c, ctx, idPath(util.WarLeagueEndpoint, id))
+}
+
+func idPath(endpoint string, id int) string {
+	return endpoint + "/" + strconv.Itoa(id)
+}
@@ -0,0 +1,44 @@
+package coc
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/MrNemo64/coc-tracker/util"
+)
+
+func (c *Client) GetLocations(ctx context.Context, opts ListOptions) (*List[Location], error) {
+	return getList[Location](c, ctx, util.LocationEndpoint, nil, opts)
+}
+
+func (c *Client) GetLocation(ctx context.Context, id int) (*Location, error) {
+	return get[Location](c, ctx, locationPath(id))
+}
+
+func (c *Client) GetClanRankings(ctx context.Context, locationId int, opts ListOptions) (*List[ClanRanking], error) {
+	return getList[ClanRanking](c, ctx, locationPath(locationId, "rankings", "clans"), nil, opts)
+}
+
+func (c *Client) GetPlayerRankings(ctx context.Context, locationId int, opts ListOptions) (*List[PlayerRanking], error) {
+	return getList[PlayerRanking](c, ctx, locationPath(locationId, "rankings", "players"), nil, opts)
+}
+
+func (c *Client) GetClanBuilderBaseRankings(ctx context.Context, locationId int, opts ListOptions) (*List[ClanRanking], error) {
+	return getList[ClanRanking](c, ctx, locationPath(locationId, "rankings", "clans-builder-base"), nil, opts)
+}
+
+func (c *Client) GetPlayerBuilderBaseRankings(ctx context.Context, locationId int, opts ListOptions) (*List[PlayerRanking], error) {
+	return getList[PlayerRanking](c, ctx, locationPath(locationId, "rankings", "players-builder-base"), nil, opts)
+}
+
+func (c *Client) GetCapitalRankings(ctx context.Context, locationId int, opts ListOptions) (*List[ClanRanking], error) {
+	return getList[ClanRanking](c, ctx, locationPath(locationId, "rankings", "capitals"), nil, opts)
+}
+
+func locationPath(id int, rest ...string) string {
+	path := util.LocationEndpoint + "/" + strconv.Itoa(id)
+	for _, segment := range rest {
+		path += "/" + segment
+	}
+	return path
+}
@@ -0,0 +1,406 @@
+package coc
+
+import (
+	"strings"
+	"time"
+)
+
+const timeLayout = "20060102T150405.000Z"
+
+// Time is a timestamp in the format used by the API, like 20240115T080000.000Z.
+type Time struct {
+	time.Time
+}
+
+func (t *Time) UnmarshalJSON(data []byte) error {
+	value := strings.Trim(string(data), `"`)
+	if value == "" || value == "null" {
+		t.Time = time.Time{}
+		return nil
+	}
+	parsed, err := time.Parse(timeLayout, value)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
+
+func (t Time) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return []byte(`"` + t.UTC().Format(timeLayout) + `"`), nil
+}
+
+type BadgeUrls struct {
+	Small  string `json:"small"`
+	Medium string `json:"medium"`
+	Large  string `json:"large"`
+}
+
+type IconUrls struct {
+	Tiny   string `json:"tiny"`
+	Small  string `json:"small"`
+	Medium string `json:"medium"`
+}
+
+type Location struct {
+	Id            int    `json:"id"`
+	Name          string `json:"name"`
+	IsCountry     bool   `json:"isCountry"`
+	CountryCode   string `json:"countryCode"`
+	LocalizedName string `json:"localizedName"`
+}
+
+// League is any of the leagues of the API: player, builder base, war and
+// capital leagues. Only player leagues have icons.
+type League struct {
+	Id       int       `json:"id"`
+	Name     string    `json:"name"`
+	IconUrls *IconUrls `json:"iconUrls,omitempty"`
+}
+
+type LeagueSeason struct {
+	Id string `json:"id"`
+}
+
+type Label struct {
+	Id       int      `json:"id"`
+	Name     string   `json:"name"`
+	IconUrls IconUrls `json:"iconUrls"`
+}
+
+type Language struct {
+	Id           int    `json:"id"`
+	Name         string `json:"name"`
+	LanguageCode string `json:"languageCode"`
+}
+
+type Clan struct {
+	Tag                         string       `json:"tag"`
+	Name                        string       `json:"name"`
+	Type                        string       `json:"type"`
+	Description                 string       `json:"description"`
+	Location                    *Location    `json:"location"`
+	IsFamilyFriendly            bool         `json:"isFamilyFriendly"`
+	BadgeUrls                   BadgeUrls    `json:"badgeUrls"`
+	ClanLevel                   int          `json:"clanLevel"`
+	ClanPoints                  int          `json:"clanPoints"`
+	ClanBuilderBasePoints       int          `json:"clanBuilderBasePoints"`
+	ClanCapitalPoints           int          `json:"clanCapitalPoints"`
+	CapitalLeague               *League      `json:"capitalLeague"`
+	RequiredTrophies            int          `json:"requiredTrophies"`
+	RequiredBuilderBaseTrophies int          `json:"requiredBuilderBaseTrophies"`
+	RequiredTownhallLevel       int          `json:"requiredTownhallLevel"`
+	WarFrequency                string       `json:"warFrequency"`
+	WarWinStreak                int          `json:"warWinStreak"`
+	WarWins                     int          `json:"warWins"`
+	WarTies                     int          `json:"warTies"`
+	WarLosses                   int          `json:"warLosses"`
+	IsWarLogPublic              bool         `json:"isWarLogPublic"`
+	WarLeague                   *League      `json:"warLeague"`
+	Members                     int          `json:"members"`
+	MemberList                  []ClanMember `json:"memberList"`
+	Labels                      []Label      `json:"labels"`
+	ChatLanguage                *Language    `json:"chatLanguage"`
+	ClanCapital                 *ClanCapital `json:"clanCapital"`
+}
+
+type ClanCapital struct {
+	CapitalHallLevel int            `json:"capitalHallLevel"`
+	Districts        []ClanDistrict `json:"districts"`
+}
+
+type ClanDistrict struct {
+	Id                int    `json:"id"`
+	Name              string `json:"name"`
+	DistrictHallLevel int    `json:"districtHallLevel"`
+}
+
+type ClanMember struct {
+	Tag                 string  `json:"tag"`
+	Name                string  `json:"name"`
+	Role                string  `json:"role"`
+	TownHallLevel       int     `json:"townHallLevel"`
+	ExpLevel            int     `json:"expLevel"`
+	League              *League `json:"league"`
+	BuilderBaseLeague   *League `json:"builderBaseLeague"`
+	Trophies            int     `json:"trophies"`
+	BuilderBaseTrophies int     `json:"builderBaseTrophies"`
+	ClanRank            int     `json:"clanRank"`
+	PreviousClanRank    int     `json:"previousClanRank"`
+	Donations           int     `json:"donations"`
+	DonationsReceived   int     `json:"donationsReceived"`
+}
+
+// ClanSearch filters the clans returned by SearchClans. At least one of the
+// filters has to be set, and Name needs at least three characters.
+type ClanSearch struct {
+	Name          string
+	WarFrequency  string
+	LocationId    int
+	MinMembers    int
+	MaxMembers    int
+	MinClanPoints int
+	MinClanLevel  int
+	LabelIds      []int
+}
+
+type ClanWar struct {
+	State                string  `json:"state"`
+	TeamSize             int     `json:"teamSize"`
+	AttacksPerMember     int     `json:"attacksPerMember"`
+	BattleModifier       string  `json:"battleModifier"`
+	PreparationStartTime Time    `json:"preparationStartTime"`
+	StartTime            Time    `json:"startTime"`
+	EndTime              Time    `json:"endTime"`
+	Clan                 WarClan `json:"clan"`
+	Opponent             WarClan `json:"opponent"`
+	// Only set for the wars of a clan war league
+	WarStartTime Time `json:"warStartTime"`
+}
+
+type WarClan struct {
+	Tag                   string          `json:"tag"`
+	Name                  string          `json:"name"`
+	BadgeUrls             BadgeUrls       `json:"badgeUrls"`
+	ClanLevel             int             `json:"clanLevel"`
+	Attacks               int             `json:"attacks"`
+	Stars                 int             `json:"stars"`
+	DestructionPercentage float64         `json:"destructionPercentage"`
+	ExpEarned             int             `json:"expEarned"`
+	Members               []ClanWarMember `json:"members"`
+}
+
+type ClanWarMember struct {
+	Tag                string          `json:"tag"`
+	Name               string          `json:"name"`
+	TownhallLevel      int             `json:"townhallLevel"`
+	MapPosition        int             `json:"mapPosition"`
+	OpponentAttacks    int             `json:"opponentAttacks"`
+	Attacks            []ClanWarAttack `json:"attacks"`
+	BestOpponentAttack *ClanWarAttack  `json:"bestOpponentAttack"`
+}
+
+type ClanWarAttack struct {
+	AttackerTag           string `json:"attackerTag"`
+	DefenderTag           string `json:"defenderTag"`
+	Stars                 int    `json:"stars"`
+	DestructionPercentage int    `json:"destructionPercentage"`
+	Order                 int    `json:"order"`
+	Duration              int    `json:"duration"`
+}
+
+type ClanWarLogEntry struct {
+	Result           string  `json:"result"`
+	EndTime          Time    `json:"endTime"`
+	TeamSize         int     `json:"teamSize"`
+	AttacksPerMember int     `json:"attacksPerMember"`
+	BattleModifier   string  `json:"battleModifier"`
+	Clan             WarClan `json:"clan"`
+	Opponent         WarClan `json:"opponent"`
+}
+
+type ClanWarLeagueGroup struct {
+	State  string               `json:"state"`
+	Season string               `json:"season"`
+	Clans  []ClanWarLeagueClan  `json:"clans"`
+	Rounds []ClanWarLeagueRound `json:"rounds"`
+}
+
+type ClanWarLeagueClan struct {
+	Tag       string                    `json:"tag"`
+	Name      string                    `json:"name"`
+	ClanLevel int                       `json:"clanLevel"`
+	BadgeUrls BadgeUrls                 `json:"badgeUrls"`
+	Members   []ClanWarLeagueClanMember `json:"members"`
+}
+
+type ClanWarLeagueClanMember struct {
+	Tag           string `json:"tag"`
+	Name          string `json:"name"`
+	TownHallLevel int    `json:"townHallLevel"`
+}
+
+type ClanWarLeagueRound struct {
+	// Tags of the wars of the round, #0 until they are decided
+	WarTags []string `json:"warTags"`
+}
+
+type ClanCapitalRaidSeason struct {
+	State                   string                         `json:"state"`
+	StartTime               Time                           `json:"startTime"`
+	EndTime                 Time                           `json:"endTime"`
+	CapitalTotalLoot        int                            `json:"capitalTotalLoot"`
+	RaidsCompleted          int                            `json:"raidsCompleted"`
+	TotalAttacks            int                            `json:"totalAttacks"`
+	EnemyDistrictsDestroyed int                            `json:"enemyDistrictsDestroyed"`
+	OffensiveReward         int                            `json:"offensiveReward"`
+	DefensiveReward         int                            `json:"defensiveReward"`
+	Members                 []ClanCapitalRaidSeasonMember  `json:"members"`
+	AttackLog               []ClanCapitalRaidSeasonRaid    `json:"attackLog"`
+	DefenseLog              []ClanCapitalRaidSeasonDefense `json:"defenseLog"`
+}
+
+type ClanCapitalRaidSeasonMember struct {
+	Tag                    string `json:"tag"`
+	Name                   string `json:"name"`
+	Attacks                int    `json:"attacks"`
+	AttackLimit            int    `json:"attackLimit"`
+	BonusAttackLimit       int    `json:"bonusAttackLimit"`
+	CapitalResourcesLooted int    `json:"capitalResourcesLooted"`
+}
+
+type ClanCapitalRaidSeasonClan struct {
+	Tag       string    `json:"tag"`
+	Name      string    `json:"name"`
+	Level     int       `json:"level"`
+	BadgeUrls BadgeUrls `json:"badgeUrls"`
+}
+
+type ClanCapitalRaidSeasonDistrict struct {
+	Id                 int    `json:"id"`
+	Name               string `json:"name"`
+	DistrictHallLevel  int    `json:"districtHallLevel"`
+	DestructionPercent int    `json:"destructionPercent"`
+	Stars              int    `json:"stars"`
+	AttackCount        int    `json:"attackCount"`
+	TotalLooted        int    `json:"totalLooted"`
+}
+
+type ClanCapitalRaidSeasonRaid struct {
+	Defender           ClanCapitalRaidSeasonClan       `json:"defender"`
+	AttackCount        int                             `json:"attackCount"`
+	DistrictCount      int                             `json:"districtCount"`
+	DistrictsDestroyed int                             `json:"districtsDestroyed"`
+	Districts          []ClanCapitalRaidSeasonDistrict `json:"districts"`
+}
+
+type ClanCapitalRaidSeasonDefense struct {
+	Attacker           ClanCapitalRaidSeasonClan       `json:"attacker"`
+	AttackCount        int                             `json:"attackCount"`
+	DistrictCount      int                             `json:"districtCount"`
+	DistrictsDestroyed int                             `json:"districtsDestroyed"`
+	Districts          []ClanCapitalRaidSeasonDistrict `json:"districts"`
+}
+
+type Player struct {
+	Tag                      string              `json:"tag"`
+	Name                     string              `json:"name"`
+	TownHallLevel            int                 `json:"townHallLevel"`
+	TownHallWeaponLevel      int                 `json:"townHallWeaponLevel"`
+	ExpLevel                 int                 `json:"expLevel"`
+	Trophies                 int                 `json:"trophies"`
+	BestTrophies             int                 `json:"bestTrophies"`
+	WarStars                 int                 `json:"warStars"`
+	AttackWins               int                 `json:"attackWins"`
+	DefenseWins              int                 `json:"defenseWins"`
+	BuilderHallLevel         int                 `json:"builderHallLevel"`
+	BuilderBaseTrophies      int                 `json:"builderBaseTrophies"`
+	BestBuilderBaseTrophies  int                 `json:"bestBuilderBaseTrophies"`
+	Role                     string              `json:"role"`
+	WarPreference            string              `json:"warPreference"`
+	Donations                int                 `json:"donations"`
+	DonationsReceived        int                 `json:"donationsReceived"`
+	ClanCapitalContributions int                 `json:"clanCapitalContributions"`
+	Clan                     *PlayerClan         `json:"clan"`
+	League                   *League             `json:"league"`
+	BuilderBaseLeague        *League             `json:"builderBaseLeague"`
+	LegendStatistics         *LegendStatistics   `json:"legendStatistics"`
+	Achievements             []PlayerAchievement `json:"achievements"`
+	Labels                   []Label             `json:"labels"`
+	Troops                   []PlayerItemLevel   `json:"troops"`
+	Heroes                   []PlayerItemLevel   `json:"heroes"`
+	HeroEquipment            []PlayerItemLevel   `json:"heroEquipment"`
+	Spells                   []PlayerItemLevel   `json:"spells"`
+}
+
+type PlayerClan struct {
+	Tag       string    `json:"tag"`
+	Name      string    `json:"name"`
+	ClanLevel int       `json:"clanLevel"`
+	BadgeUrls BadgeUrls `json:"badgeUrls"`
+}
+
+type LegendStatistics struct {
+	LegendTrophies            int                 `json:"legendTrophies"`
+	CurrentSeason             *LegendLeagueSeason `json:"currentSeason"`
+	PreviousSeason            *LegendLeagueSeason `json:"previousSeason"`
+	BestSeason                *LegendLeagueSeason `json:"bestSeason"`
+	PreviousBuilderBaseSeason *LegendLeagueSeason `json:"previousBuilderBaseSeason"`
+	BestBuilderBaseSeason     *LegendLeagueSeason `json:"bestBuilderBaseSeason"`
+}
+
+type LegendLeagueSeason struct {
+	Id       string `json:"id"`
+	Rank     int    `json:"rank"`
+	Trophies int    `json:"trophies"`
+}
+
+type PlayerAchievement struct {
+	Name           string `json:"name"`
+	Stars          int    `json:"stars"`
+	Value          int    `json:"value"`
+	Target         int    `json:"target"`
+	Info           string `json:"info"`
+	CompletionInfo string `json:"completionInfo"`
+	Village        string `json:"village"`
+}
+
+type PlayerItemLevel struct {
+	Name               string `json:"name"`
+	Level              int    `json:"level"`
+	MaxLevel           int    `json:"maxLevel"`
+	Village            string `json:"village"`
+	SuperTroopIsActive bool   `json:"superTroopIsActive"`
+}
+
+type VerifyTokenResponse struct {
+	Tag    string `json:"tag"`
+	Token  string `json:"token"`
+	Status string `json:"status"`
+}
+
+// Valid reports whether the token belonged to the player.
+func (r *VerifyTokenResponse) Valid() bool {
+	return r.Status == "ok"
+}
+
+// ClanRanking is a clan in any of the clan rankings of a location. Only the
+// points of the ranking the clan was listed in are set.
+type ClanRanking struct {
+	Tag                   string    `json:"tag"`
+	Name                  string    `json:"name"`
+	Location              *Location `json:"location"`
+	BadgeUrls             BadgeUrls `json:"badgeUrls"`
+	ClanLevel             int       `json:"clanLevel"`
+	Members               int       `json:"members"`
+	ClanPoints            int       `json:"clanPoints"`
+	ClanBuilderBasePoints int       `json:"clanBuilderBasePoints"`
+	ClanCapitalPoints     int       `json:"clanCapitalPoints"`
+	Rank                  int       `json:"rank"`
+	PreviousRank          int       `json:"previousRank"`
+}
+
+// PlayerRanking is a player in any of the player rankings of a location or of
+// a legend league season.
+type PlayerRanking struct {
+	Tag                 string      `json:"tag"`
+	Name                string      `json:"name"`
+	ExpLevel            int         `json:"expLevel"`
+	Trophies            int         `json:"trophies"`
+	BuilderBaseTrophies int         `json:"builderBaseTrophies"`
+	AttackWins          int         `json:"attackWins"`
+	DefenseWins         int         `json:"defenseWins"`
+	Rank                int         `json:"rank"`
+	PreviousRank        int         `json:"previousRank"`
+	Clan                *PlayerClan `json:"clan"`
+	League              *League     `json:"league"`
+	BuilderBaseLeague   *League     `json:"builderBaseLeague"`
+}
+
+type GoldPassSeason struct {
+	StartTime Time `json:"startTime"`
+	EndTime   Time `json:"endTime"`
+}
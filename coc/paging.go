@@ -0,0 +1,121 @@
+package coc
+
+import "context"
+
+// PageFetcher requests one page of a list endpoint. Methods like
+// Client.GetLocations are fetchers, endpoints with more parameters can be
+// wrapped in a closure.
+type PageFetcher[T any] func(ctx context.Context, opts ListOptions) (*List[T], error)
+
+// Pager walks the pages of a list endpoint following its cursors, in the
+// direction of the cursor given in the starting options: forwards unless only
+// Before is set. Use it like a bufio.Scanner:
+//
+//	pager := coc.Paginate(client.GetLocations, coc.ListOptions{Limit: 100})
+//	for pager.Next(ctx) {
+//		store(pager.Page())
+//	}
+//	if err := pager.Err(); err != nil {
+//		...
+//	}
+type Pager[T any] struct {
+	fetch     PageFetcher[T]
+	opts      ListOptions
+	backwards bool
+	page      []T
+	cacheHit  bool
+	pages     int
+	done      bool
+	err       error
+}
+
+func Paginate[T any](fetch PageFetcher[T], opts ListOptions) *Pager[T] {
+	return &Pager[T]{
+		fetch:     fetch,
+		opts:      opts,
+		backwards: opts.Before != "" && opts.After == "",
+	}
+}
+
+// Next fetches the next page, returning false once there are no more pages or
+// a request failed.
+func (p *Pager[T]) Next(ctx context.Context) bool {
+	if p.done {
+		p.page = nil
+		p.cacheHit = false
+		return false
+	}
+
+	list, err := p.fetch(ctx, p.opts)
+	if err != nil {
+		p.err = err
+		p.done = true
+		p.page = nil
+		p.cacheHit = false
+		return false
+	}
+	p.page = list.Items
+	p.cacheHit = list.CacheHit
+	p.pages++
+
+	cursor := list.Paging.Cursors.After
+	if p.backwards {
+		cursor = list.Paging.Cursors.Before
+	}
+	if cursor == "" {
+		p.done = true
+	} else if p.backwards {
+		p.opts.After, p.opts.Before = "", cursor
+	} else {
+		p.opts.After, p.opts.Before = cursor, ""
+	}
+	// An empty page with a cursor would be requested forever
+	if len(list.Items) == 0 {
+		p.done = true
+	}
+	return true
+}
+
+// Page returns the items of the page fetched by the last call to Next.
+func (p *Pager[T]) Page() []T {
+	return p.page
+}
+
+// CacheHit reports whether the page fetched by the last call to Next was
+// served from the cache.
+func (p *Pager[T]) CacheHit() bool {
+	return p.cacheHit
+}
+
+// Pages returns how many pages have been fetched so far.
+func (p *Pager[T]) Pages() int {
+	return p.pages
+}
+
+func (p *Pager[T]) Err() error {
+	return p.err
+}
+
+// Each calls fn with every remaining page, stopping at the first error, so the
+// items can be processed without keeping all of them in memory.
+func (p *Pager[T]) Each(ctx context.Context, fn func(page []T) error) error {
+	for p.Next(ctx) {
+		if err := fn(p.Page()); err != nil {
+			return err
+		}
+	}
+	return p.Err()
+}
+
+// All returns the items of every remaining page.
+func (p *Pager[T]) All(ctx context.Context) ([]T, error) {
+	var items []T
+	err := p.Each(ctx, func(page []T) error {
+		items = append(items, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,72 @@
+package coc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/MrNemo64/coc-tracker/coc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaginate(t *testing.T) {
+	t.Parallel()
+
+	requester := &fakeRequester{responses: map[string]fakeResponse{
+		"/locations?limit=2":              {http.StatusOK, `{"items": [{"id": 1}, {"id": 2}], "paging": {"cursors": {"after": "c2"}}}`},
+		"/locations?after=c2&limit=2":     {http.StatusOK, `{"items": [{"id": 3}, {"id": 4}], "paging": {"cursors": {"before": "c1", "after": "c4"}}}`},
+		"/locations?after=c4&limit=2":     {http.StatusOK, `{"items": [{"id": 5}], "paging": {"cursors": {"before": "c3"}}}`},
+		"/locations?before=c3&limit=2":    {http.StatusOK, `{"items": [{"id": 3}, {"id": 4}], "paging": {"cursors": {"before": "c1", "after": "c4"}}}`},
+		"/locations?before=c1&limit=2":    {http.StatusOK, `{"items": [{"id": 1}, {"id": 2}], "paging": {"cursors": {"after": "c2"}}}`},
+		"/locations?after=broken&limit=2": {http.StatusOK, `{"items": [{"id": 9}], "paging": {"cursors": {"after": "missing"}}}`},
+	}}
+	client := coc.NewClient(requester)
+	ids := func(locations []coc.Location) []int {
+		result := []int{}
+		for _, location := range locations {
+			result = append(result, location.Id)
+		}
+		return result
+	}
+
+	requester.cached = map[string]bool{"/locations?after=c2&limit=2": true}
+	pager := coc.Paginate(client.GetLocations, coc.ListOptions{Limit: 2})
+	var cacheHits []bool
+	for pager.Next(context.Background()) {
+		cacheHits = append(cacheHits, pager.CacheHit())
+	}
+	assert.NoError(t, pager.Err())
+	assert.Equal(t, []bool{false, true, false}, cacheHits)
+	requester.cached = nil
+
+	pager = coc.Paginate(client.GetLocations, coc.ListOptions{Limit: 2})
+	all, err := pager.All(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, ids(all))
+	assert.Equal(t, 3, pager.Pages())
+	assert.False(t, pager.Next(context.Background()))
+
+	var pages [][]int
+	err = coc.Paginate(client.GetLocations, coc.ListOptions{Limit: 2, Before: "c3"}).Each(context.Background(), func(page []coc.Location) error {
+		pages = append(pages, ids(page))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]int{{3, 4}, {1, 2}}, pages)
+
+	pager = coc.Paginate(client.GetLocations, coc.ListOptions{Limit: 2, After: "broken"})
+	assert.True(t, pager.Next(context.Background()))
+	assert.Equal(t, []int{9}, ids(pager.Page()))
+	assert.False(t, pager.Next(context.Background()))
+	assert.Error(t, pager.Err())
+
+	stop := errors.New("stop")
+	calls := 0
+	err = coc.Paginate(client.GetLocations, coc.ListOptions{Limit: 2}).Each(context.Background(), func([]coc.Location) error {
+		calls++
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, calls)
+}
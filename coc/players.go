@@ -0,0 +1,17 @@
+package coc
+
+import (
+	"context"
+
+	"github.com/MrNemo64/coc-tracker/util"
+)
+
+func (c *Client) GetPlayer(ctx context.Context, tag string) (*Player, error) {
+	return get[Player](c, ctx, tagPath(util.PlayerEndpoint, tag))
+}
+
+// VerifyPlayerToken checks the API token shown in the settings of the game
+// belongs to the player. The requester has to be a Poster.
+func (c *Client) VerifyPlayerToken(ctx context.Context, tag string, token string) (*VerifyTokenResponse, error) {
+	return post[VerifyTokenResponse](c, ctx, tagPath(util.PlayerEndpoint, tag, "verifytoken"), map[string]string{"token": token})
+}
@@ -38,8 +38,12 @@ func DatabaseConfigurationFromEnv() DatabaseConfiguration {
 	}
 }
 
+func (conf DatabaseConfiguration) ConnectionString() string {
+	return fmt.Sprintf("host=%s port=%d database=%s user=%s password=%s sslmode=%s", conf.Host, conf.Port, conf.Database, conf.User, conf.Password, conf.SSL)
+}
+
 func ConnectToDatabase(conf DatabaseConfiguration) (*sqlx.DB, error) {
-	return sqlx.Connect("postgres", fmt.Sprintf("host=%s port=%d database=%s user=%s password=%s sslmode=%s", conf.Host, conf.Port, conf.Database, conf.User, conf.Password, conf.SSL))
+	return sqlx.Connect("postgres", conf.ConnectionString())
 }
 
 func Migrate(db *sqlx.DB) error {
@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP TABLE api_cache;
+
+COMMIT;
\ No newline at end of file
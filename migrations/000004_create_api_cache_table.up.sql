@@ -0,0 +1,13 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS api_cache (
+    url VARCHAR PRIMARY KEY,
+    status_code INTEGER NOT NULL,
+    etag VARCHAR NOT NULL DEFAULT '',
+    content_type VARCHAR NOT NULL DEFAULT '',
+    body BYTEA NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,17 @@
+BEGIN;
+
+UPDATE jobs SET state = 'pending' WHERE state = 'failed';
+
+ALTER TABLE jobs
+    DROP COLUMN attempts,
+    DROP COLUMN last_error;
+
+ALTER TYPE job_state RENAME TO job_state_old;
+CREATE TYPE job_state AS ENUM ('pending', 'queued', 'running');
+ALTER TABLE jobs
+    ALTER COLUMN state DROP DEFAULT,
+    ALTER COLUMN state TYPE job_state USING state::text::job_state,
+    ALTER COLUMN state SET DEFAULT 'pending';
+DROP TYPE job_state_old;
+
+COMMIT;
\ No newline at end of file
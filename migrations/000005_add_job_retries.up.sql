@@ -0,0 +1,9 @@
+BEGIN;
+
+ALTER TYPE job_state ADD VALUE IF NOT EXISTS 'failed';
+
+ALTER TABLE jobs
+    ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN last_error TEXT;
+
+COMMIT;
\ No newline at end of file
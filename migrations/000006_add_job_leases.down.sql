@@ -0,0 +1,9 @@
+BEGIN;
+
+DROP INDEX jobs_locked_until_idx;
+
+ALTER TABLE jobs
+    DROP COLUMN locked_until,
+    DROP COLUMN worker_id;
+
+COMMIT;
\ No newline at end of file
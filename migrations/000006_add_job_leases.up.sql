@@ -0,0 +1,9 @@
+BEGIN;
+
+ALTER TABLE jobs
+    ADD COLUMN locked_until TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN worker_id VARCHAR;
+
+CREATE INDEX jobs_locked_until_idx ON jobs (locked_until) WHERE state IN ('queued', 'running');
+
+COMMIT;
\ No newline at end of file
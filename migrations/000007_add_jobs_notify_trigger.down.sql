@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP TRIGGER jobs_notify_available ON jobs;
+DROP FUNCTION notify_jobs_available();
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,16 @@
+BEGIN;
+
+CREATE OR REPLACE FUNCTION notify_jobs_available() RETURNS trigger AS $$
+BEGIN
+    IF NEW.state = 'pending' THEN
+        PERFORM pg_notify('jobs_available', NEW.name);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER jobs_notify_available
+AFTER INSERT OR UPDATE OF state, available_at ON jobs
+FOR EACH ROW EXECUTE FUNCTION notify_jobs_available();
+
+COMMIT;
\ No newline at end of file
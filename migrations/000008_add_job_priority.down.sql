@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP INDEX jobs_pending_priority_idx;
+
+ALTER TABLE jobs DROP COLUMN priority;
+
+COMMIT;
\ No newline at end of file
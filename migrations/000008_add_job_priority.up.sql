@@ -0,0 +1,7 @@
+BEGIN;
+
+ALTER TABLE jobs ADD COLUMN priority INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX jobs_pending_priority_idx ON jobs (priority DESC, available_at ASC) WHERE state = 'pending';
+
+COMMIT;
\ No newline at end of file
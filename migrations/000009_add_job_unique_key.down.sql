@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP INDEX jobs_unique_key_idx;
+
+ALTER TABLE jobs DROP COLUMN unique_key;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,8 @@
+BEGIN;
+
+ALTER TABLE jobs ADD COLUMN unique_key VARCHAR;
+
+CREATE UNIQUE INDEX jobs_unique_key_idx ON jobs (unique_key)
+WHERE unique_key IS NOT NULL AND state IN ('pending', 'queued', 'running');
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP TABLE IF EXISTS job_runs;
+
+DROP TYPE IF EXISTS job_run_outcome;
+
+COMMIT;
\ No newline at end of file
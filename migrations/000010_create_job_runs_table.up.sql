@@ -0,0 +1,24 @@
+BEGIN;
+
+CREATE TYPE job_run_outcome AS ENUM ('succeeded', 'unsuccessful', 'retrying', 'failed', 'interrupted', 'lease_lost');
+
+CREATE TABLE IF NOT EXISTS job_runs (
+    id BIGSERIAL PRIMARY KEY,
+    job_id BIGINT NOT NULL,
+    name VARCHAR NOT NULL,
+    attempt INTEGER NOT NULL,
+    worker_id VARCHAR NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    finished_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    outcome job_run_outcome NOT NULL,
+    error TEXT,
+    http_calls INTEGER NOT NULL DEFAULT 0,
+    cache_hits INTEGER NOT NULL DEFAULT 0,
+    rows_written BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE INDEX job_runs_name_started_at_idx ON job_runs (name, started_at DESC);
+CREATE INDEX job_runs_job_id_idx ON job_runs (job_id);
+CREATE INDEX job_runs_finished_at_idx ON job_runs (finished_at);
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,22 @@
+BEGIN;
+
+UPDATE jobs SET state = 'pending' WHERE state = 'blocked';
+
+DROP INDEX jobs_locked_until_idx;
+DROP INDEX jobs_pending_priority_idx;
+DROP INDEX jobs_unique_key_idx;
+
+ALTER TYPE job_state RENAME TO job_state_old;
+CREATE TYPE job_state AS ENUM ('pending', 'queued', 'running', 'failed');
+ALTER TABLE jobs
+    ALTER COLUMN state DROP DEFAULT,
+    ALTER COLUMN state TYPE job_state USING state::text::job_state,
+    ALTER COLUMN state SET DEFAULT 'pending';
+DROP TYPE job_state_old;
+
+CREATE INDEX jobs_locked_until_idx ON jobs (locked_until) WHERE state IN ('queued', 'running');
+CREATE INDEX jobs_pending_priority_idx ON jobs (priority DESC, available_at ASC) WHERE state = 'pending';
+CREATE UNIQUE INDEX jobs_unique_key_idx ON jobs (unique_key)
+WHERE unique_key IS NOT NULL AND state IN ('pending', 'queued', 'running');
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,5 @@
+BEGIN;
+
+ALTER TYPE job_state ADD VALUE IF NOT EXISTS 'blocked';
+
+COMMIT;
\ No newline at end of file
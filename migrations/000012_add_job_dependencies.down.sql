@@ -0,0 +1,13 @@
+BEGIN;
+
+DROP INDEX jobs_unique_key_idx;
+CREATE UNIQUE INDEX jobs_unique_key_idx ON jobs (unique_key)
+WHERE unique_key IS NOT NULL AND state IN ('pending', 'queued', 'running');
+
+DROP TABLE IF EXISTS job_dependencies;
+
+DROP INDEX jobs_parent_id_idx;
+
+ALTER TABLE jobs DROP COLUMN parent_id;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,19 @@
+BEGIN;
+
+ALTER TABLE jobs ADD COLUMN parent_id BIGINT;
+
+CREATE INDEX jobs_parent_id_idx ON jobs (parent_id) WHERE parent_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS job_dependencies (
+    job_id BIGINT NOT NULL REFERENCES jobs (id) ON DELETE CASCADE,
+    depends_on BIGINT NOT NULL,
+    PRIMARY KEY (job_id, depends_on)
+);
+
+CREATE INDEX job_dependencies_depends_on_idx ON job_dependencies (depends_on);
+
+DROP INDEX jobs_unique_key_idx;
+CREATE UNIQUE INDEX jobs_unique_key_idx ON jobs (unique_key)
+WHERE unique_key IS NOT NULL AND state IN ('pending', 'queued', 'running', 'blocked');
+
+COMMIT;
\ No newline at end of file
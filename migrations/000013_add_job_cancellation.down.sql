@@ -0,0 +1,12 @@
+BEGIN;
+
+ALTER TABLE jobs DROP COLUMN cancel_requested;
+
+UPDATE job_runs SET outcome = 'failed' WHERE outcome = 'cancelled';
+
+ALTER TYPE job_run_outcome RENAME TO job_run_outcome_old;
+CREATE TYPE job_run_outcome AS ENUM ('succeeded', 'unsuccessful', 'retrying', 'failed', 'interrupted', 'lease_lost');
+ALTER TABLE job_runs ALTER COLUMN outcome TYPE job_run_outcome USING outcome::text::job_run_outcome;
+DROP TYPE job_run_outcome_old;
+
+COMMIT;
\ No newline at end of file
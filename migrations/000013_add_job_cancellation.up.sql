@@ -0,0 +1,7 @@
+BEGIN;
+
+ALTER TABLE jobs ADD COLUMN cancel_requested BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TYPE job_run_outcome ADD VALUE IF NOT EXISTS 'cancelled';
+
+COMMIT;
\ No newline at end of file
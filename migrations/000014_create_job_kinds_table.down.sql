@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP TABLE IF EXISTS job_kinds;
+
+COMMIT;
\ No newline at end of file
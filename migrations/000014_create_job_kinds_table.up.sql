@@ -0,0 +1,11 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS job_kinds (
+    name VARCHAR PRIMARY KEY,
+    paused BOOLEAN NOT NULL DEFAULT FALSE,
+    paused_at TIMESTAMP WITH TIME ZONE,
+    pause_reason TEXT,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0
+);
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,11 @@
+BEGIN;
+
+UPDATE job_runs SET outcome = 'failed' WHERE outcome = 'stopped';
+UPDATE job_runs SET outcome = 'retrying' WHERE outcome = 'deferred';
+
+ALTER TYPE job_run_outcome RENAME TO job_run_outcome_old;
+CREATE TYPE job_run_outcome AS ENUM ('succeeded', 'unsuccessful', 'retrying', 'failed', 'interrupted', 'lease_lost', 'cancelled');
+ALTER TABLE job_runs ALTER COLUMN outcome TYPE job_run_outcome USING outcome::text::job_run_outcome;
+DROP TYPE job_run_outcome_old;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TYPE job_run_outcome ADD VALUE IF NOT EXISTS 'stopped';
+ALTER TYPE job_run_outcome ADD VALUE IF NOT EXISTS 'deferred';
+
+COMMIT;
\ No newline at end of file
@@ -18,8 +18,9 @@ import (
 )
 
 type TestDatabase struct {
-	container *postgres.PostgresContainer
-	DB        *sqlx.DB
+	container        *postgres.PostgresContainer
+	DB               *sqlx.DB
+	ConnectionString string
 }
 
 func (tb *TestDatabase) Shutdown() error {
@@ -62,21 +63,23 @@ func CreatePostgresContainer() (*TestDatabase, error) {
 		return nil, fmt.Errorf("failed parse the test database post: %v", err)
 	}
 
-	dbCon, err := db.ConnectToDatabase(db.DatabaseConfiguration{
+	dbConf := db.DatabaseConfiguration{
 		Host:     "localhost",
 		Port:     port,
 		Database: dbName,
 		User:     dbUser,
 		Password: dbPassword,
 		SSL:      "disable",
-	})
+	}
+	dbCon, err := db.ConnectToDatabase(dbConf)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to test database: %v", err)
 	}
 
 	testContainer := &TestDatabase{
-		container: postgresContainer,
-		DB:        dbCon,
+		container:        postgresContainer,
+		DB:               dbCon,
+		ConnectionString: dbConf.ConnectionString(),
 	}
 
 	err = db.Migrate(dbCon)
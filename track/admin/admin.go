@@ -0,0 +1,410 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/MrNemo64/coc-tracker/track/jobs"
+	"github.com/jmoiron/sqlx"
+)
+
+const maxBodySize = 1 << 20
+
+// KeyReporter reports the health of the API keys of a tracker instance.
+type KeyReporter interface {
+	// Returns a snapshot of the keys that can be encoded as JSON
+	KeysHealth() any
+}
+
+// Server exposes the jobs of a tracker instance over HTTP.
+type Server struct {
+	db     *sqlx.DB
+	jobs   *jobs.RegisteredJobs
+	keys   KeyReporter
+	logger *slog.Logger
+	// Required as a bearer token on every request if not empty
+	token string
+	mux   *http.ServeMux
+}
+
+// keys may be nil, the key endpoint is not served then.
+func NewServer(db *sqlx.DB, queue *jobs.RegisteredJobs, keys KeyReporter, logger *slog.Logger, token string) *Server {
+	s := &Server{db: db, jobs: queue, keys: keys, logger: logger, token: token, mux: http.NewServeMux()}
+
+	s.mux.HandleFunc("GET /jobs", s.listJobs)
+	s.mux.HandleFunc("POST /jobs/requeue", s.requeueJobs)
+	s.mux.HandleFunc("GET /jobs/{id}", s.getJob)
+	s.mux.HandleFunc("POST /jobs/{id}/trigger", s.triggerJob)
+	s.mux.HandleFunc("POST /jobs/{id}/cancel", s.cancelJob)
+	s.mux.HandleFunc("POST /jobs/{id}/requeue", s.requeueJob)
+
+	s.mux.HandleFunc("GET /kinds", s.listKinds)
+	s.mux.HandleFunc("POST /kinds/{name...}", s.kindAction)
+
+	if keys != nil {
+		s.mux.HandleFunc("GET /keys", s.listKeys)
+	}
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" {
+		given := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(given), []byte("Bearer "+s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+type jobResponse struct {
+	Id              int64           `json:"id"`
+	Name            string          `json:"name"`
+	Data            json.RawMessage `json:"data"`
+	State           jobs.JobState   `json:"state"`
+	CreatedAt       time.Time       `json:"created_at"`
+	AvailableAt     time.Time       `json:"available_at"`
+	Attempts        int             `json:"attempts"`
+	LastError       *string         `json:"last_error,omitempty"`
+	LockedUntil     *time.Time      `json:"locked_until,omitempty"`
+	WorkerId        *string         `json:"worker_id,omitempty"`
+	Priority        int             `json:"priority"`
+	ParentId        *int64          `json:"parent_id,omitempty"`
+	CancelRequested bool            `json:"cancel_requested"`
+}
+
+func toJobResponse(job jobs.DBJob) jobResponse {
+	return jobResponse{
+		Id:              job.Id,
+		Name:            job.Name,
+		Data:            json.RawMessage(job.Data),
+		State:           job.State,
+		CreatedAt:       job.CreatedAt,
+		AvailableAt:     job.AvailableAt,
+		Attempts:        job.Attempts,
+		LastError:       job.LastError,
+		LockedUntil:     job.LockedUntil,
+		WorkerId:        job.WorkerId,
+		Priority:        job.Priority,
+		ParentId:        job.ParentId,
+		CancelRequested: job.CancelRequested,
+	}
+}
+
+type runResponse struct {
+	Id          int64              `json:"id"`
+	Attempt     int                `json:"attempt"`
+	WorkerId    string             `json:"worker_id"`
+	StartedAt   time.Time          `json:"started_at"`
+	FinishedAt  time.Time          `json:"finished_at"`
+	Duration    string             `json:"duration"`
+	Outcome     jobs.JobRunOutcome `json:"outcome"`
+	Error       *string            `json:"error,omitempty"`
+	HttpCalls   int                `json:"http_calls"`
+	CacheHits   int                `json:"cache_hits"`
+	RowsWritten int64              `json:"rows_written"`
+}
+
+func toRunResponse(run jobs.JobRun) runResponse {
+	return runResponse{
+		Id:          run.Id,
+		Attempt:     run.Attempt,
+		WorkerId:    run.WorkerId,
+		StartedAt:   run.StartedAt,
+		FinishedAt:  run.FinishedAt,
+		Duration:    run.Duration().String(),
+		Outcome:     run.Outcome,
+		Error:       run.Error,
+		HttpCalls:   run.HttpCalls,
+		CacheHits:   run.CacheHits,
+		RowsWritten: run.RowsWritten,
+	}
+}
+
+func (s *Server) listJobs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := jobs.JobFilter{
+		Name:  query.Get("name"),
+		State: jobs.JobState(query.Get("state")),
+	}
+	var err error
+	if filter.Limit, err = intParam(query.Get("limit"), 100); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %w", err))
+		return
+	}
+	if filter.Offset, err = intParam(query.Get("offset"), 0); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid offset: %w", err))
+		return
+	}
+
+	found, err := jobs.ListJobs(s.db, filter)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	response := make([]jobResponse, 0, len(found))
+	for _, job := range found {
+		response = append(response, toJobResponse(job))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobId(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := jobs.GetJob(s.db, id)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	runs, err := jobs.ListJobRuns(s.db, jobs.JobRunFilter{JobId: id, Limit: 50})
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	if job == nil && len(runs) == 0 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job %d not found", id))
+		return
+	}
+	children, err := jobs.ChildrenOf(s.db, id)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+
+	response := struct {
+		// Nil once the job finished and was removed
+		Job      *jobResponse  `json:"job"`
+		Runs     []runResponse `json:"runs"`
+		Children []int64       `json:"children"`
+	}{Runs: []runResponse{}, Children: []int64{}}
+	if job != nil {
+		converted := toJobResponse(*job)
+		response.Job = &converted
+	}
+	for _, run := range runs {
+		response.Runs = append(response.Runs, toRunResponse(run))
+	}
+	for _, child := range children {
+		response.Children = append(response.Children, child.Id)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) triggerJob(w http.ResponseWriter, r *http.Request) {
+	s.jobAction(w, r, "is not pending", func(id int64) (bool, error) {
+		return jobs.TriggerJob(s.db, id)
+	})
+}
+
+func (s *Server) cancelJob(w http.ResponseWriter, r *http.Request) {
+	s.jobAction(w, r, "has already finished", func(id int64) (bool, error) {
+		return s.jobs.CancelJob(s.db, id)
+	})
+}
+
+func (s *Server) requeueJob(w http.ResponseWriter, r *http.Request) {
+	s.jobAction(w, r, "has not failed", func(id int64) (bool, error) {
+		return jobs.RequeueJob(s.db, id)
+	})
+}
+
+// jobAction runs an action on the job in the path, answering with a conflict
+// if the job is not in a state the action applies to.
+func (s *Server) jobAction(w http.ResponseWriter, r *http.Request, conflict string, action func(id int64) (bool, error)) {
+	id, ok := jobId(w, r)
+	if !ok {
+		return
+	}
+	done, err := action(id)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	if !done {
+		writeError(w, http.StatusConflict, fmt.Errorf("job %d does not exist or %s", id, conflict))
+		return
+	}
+	s.logger.Info("Job updated through the admin API", "job-id", id, "action", r.URL.Path)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) requeueJobs(w http.ResponseWriter, r *http.Request) {
+	count, err := jobs.RequeueFailedJobs(s.db, r.URL.Query().Get("name"))
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{"requeued": count})
+}
+
+type kindResponse struct {
+	Name                string     `json:"name"`
+	Paused              bool       `json:"paused"`
+	PausedAt            *time.Time `json:"paused_at,omitempty"`
+	PauseReason         *string    `json:"pause_reason,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+}
+
+func (s *Server) listKinds(w http.ResponseWriter, r *http.Request) {
+	stored, err := jobs.ListJobKinds(s.db)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	byName := make(map[string]jobs.JobKind, len(stored))
+	for _, kind := range stored {
+		byName[kind.Name] = kind
+	}
+
+	response := []kindResponse{}
+	for _, name := range s.jobs.JobNames() {
+		kind := byName[name]
+		response = append(response, kindResponse{
+			Name:                name,
+			Paused:              kind.Paused,
+			PausedAt:            kind.PausedAt,
+			PauseReason:         kind.PauseReason,
+			ConsecutiveFailures: kind.ConsecutiveFailures,
+		})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// kindAction handles POST /kinds/{name}/{action}. Kind names contain slashes,
+// so the action is the last segment of the path. Pause and resume take a
+// pattern instead of a name, as in jobs.RegisteredJobs.PauseKinds.
+func (s *Server) kindAction(w http.ResponseWriter, r *http.Request) {
+	value := r.PathValue("name")
+	var name, action string
+	for i := len(value) - 1; i >= 0; i-- {
+		if value[i] == '/' {
+			name, action = value[:i], value[i+1:]
+			break
+		}
+	}
+
+	var names []string
+	var err error
+	switch action {
+	case "pause":
+		names, err = s.jobs.PauseKinds(s.db, name, r.URL.Query().Get("reason"))
+	case "resume":
+		names, err = s.jobs.ResumeKinds(s.db, name)
+	case "trigger":
+		if s.jobs.FindJobProvider(name) == nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no provider registered for job %q", name))
+			return
+		}
+		s.triggerKind(w, r, name)
+		return
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown action %q", action))
+		return
+	}
+	if errors.Is(err, path.ErrBadPattern) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	if len(names) == 0 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no registered job matches %q", name))
+		return
+	}
+	s.logger.Info("Job kinds updated through the admin API", "job-names", names, "action", action)
+	writeJSON(w, http.StatusOK, map[string]any{"names": names, "paused": action == "pause"})
+}
+
+// triggerKind enqueues a job of the kind to run right away, using the request
+// body as its data.
+func (s *Server) triggerKind(w http.ResponseWriter, r *http.Request, name string) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var data any
+	if len(body) > 0 {
+		if !json.Valid(body) {
+			writeError(w, http.StatusBadRequest, errors.New("job data must be JSON"))
+			return
+		}
+		data = body
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	defer tx.Rollback()
+	id, err := s.jobs.Enqueue(tx, name, data, jobs.EnqueueOptions{})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		s.internalError(w, err)
+		return
+	}
+	s.logger.Info("Job enqueued through the admin API", "job-id", id, "job-name", name)
+	writeJSON(w, http.StatusCreated, map[string]int64{"id": id})
+}
+
+func jobId(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid job id %q", r.PathValue("id")))
+		return 0, false
+	}
+	return id, true
+}
+
+func intParam(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("must not be negative, got %d", n)
+	}
+	return n, nil
+}
+
+func (s *Server) listKeys(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.keys.KeysHealth())
+}
+
+func (s *Server) internalError(w http.ResponseWriter, err error) {
+	s.logger.Error("Error handling admin request", "err", err)
+	writeError(w, http.StatusInternalServerError, errors.New("internal error"))
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,212 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	testutil "github.com/MrNemo64/coc-tracker/test_util"
+	"github.com/MrNemo64/coc-tracker/track/admin"
+	"github.com/MrNemo64/coc-tracker/track/jobs"
+	"github.com/MrNemo64/coc-tracker/util"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	util.LoadEnv()
+	os.Exit(m.Run())
+}
+
+type mockJobProvider struct {
+	name string
+}
+
+func (p *mockJobProvider) Deserialize(string) (jobs.Job, error) { return nil, nil }
+func (p *mockJobProvider) Save(*sqlx.Tx, *jobs.ScheduleInformation) error {
+	return nil
+}
+func (p *mockJobProvider) JobName() string { return p.name }
+
+func request(t *testing.T, server http.Handler, method, path, body string) (int, map[string]any) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+
+	var decoded map[string]any
+	if recorder.Body.Len() > 0 && strings.HasPrefix(recorder.Body.String(), "{") {
+		if err := json.Unmarshal(recorder.Body.Bytes(), &decoded); err != nil {
+			t.Fatalf("Invalid response body %q: %v", recorder.Body.String(), err)
+		}
+	}
+	return recorder.Code, decoded
+}
+
+func TestKindEndpoints(t *testing.T) {
+	t.Parallel()
+
+	container, err := testutil.CreatePostgresContainer()
+	if err != nil {
+		t.Fatalf("Could not set up test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			t.Errorf("Error shuting down test container: %v", err)
+		}
+	})
+
+	queue := jobs.NewJobQueue()
+	queue.RegisterJobKind(&mockJobProvider{name: "update/Clan"})
+	queue.RegisterJobKind(&mockJobProvider{name: "update/Player"})
+	server := admin.NewServer(container.DB, queue, nil, testutil.MakeTestLogger().Logger, "secret")
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/kinds", nil))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	status, body := request(t, server, http.MethodPost, "/kinds/update/*/pause?reason=maintenance", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, map[string]any{"names": []any{"update/Clan", "update/Player"}, "paused": true}, body)
+	assert.True(t, queue.IsPaused("update/Clan"))
+
+	status, body = request(t, server, http.MethodPost, "/kinds/update/Clan/resume", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, map[string]any{"names": []any{"update/Clan"}, "paused": false}, body)
+	assert.False(t, queue.IsPaused("update/Clan"))
+	assert.True(t, queue.IsPaused("update/Player"))
+
+	recorder = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/kinds", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	server.ServeHTTP(recorder, req)
+	var listed []map[string]any
+	if err := json.Unmarshal(recorder.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("Invalid response body %q: %v", recorder.Body.String(), err)
+	}
+	if assert.Len(t, listed, 2) {
+		assert.Equal(t, false, listed[0]["paused"])
+		assert.Equal(t, true, listed[1]["paused"])
+		assert.Equal(t, "maintenance", listed[1]["pause_reason"])
+	}
+
+	status, _ = request(t, server, http.MethodPost, "/kinds/update/Building/pause", "")
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = request(t, server, http.MethodPost, "/kinds/update/[/pause", "")
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = request(t, server, http.MethodPost, "/kinds/update/Clan/explode", "")
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestJobEndpoints(t *testing.T) {
+	t.Parallel()
+
+	container, err := testutil.CreatePostgresContainer()
+	if err != nil {
+		t.Fatalf("Could not set up test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			t.Errorf("Error shuting down test container: %v", err)
+		}
+	})
+
+	for _, query := range []string{
+		`INSERT INTO jobs (name, data, available_at) VALUES ('update/Clan', '{"tag": "#ABC"}', CURRENT_TIMESTAMP + INTERVAL '1 hour')`,
+		`INSERT INTO jobs (name, state, last_error) VALUES ('update/Clan', 'failed', 'boom')`,
+		`INSERT INTO jobs (name, state, worker_id) VALUES ('update/Clan', 'running', 'other-instance')`,
+	} {
+		if _, err := container.DB.Exec(query); err != nil {
+			t.Fatalf("Error inserting job: %v", err)
+		}
+	}
+
+	queue := jobs.NewJobQueue()
+	queue.RegisterJobKind(&mockJobProvider{name: "update/Clan"})
+	server := admin.NewServer(container.DB, queue, nil, testutil.MakeTestLogger().Logger, "secret")
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/jobs?state=failed", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	server.ServeHTTP(recorder, req)
+	var listed []map[string]any
+	if err := json.Unmarshal(recorder.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("Invalid response body %q: %v", recorder.Body.String(), err)
+	}
+	if assert.Len(t, listed, 1) {
+		assert.Equal(t, float64(2), listed[0]["id"])
+		assert.Equal(t, "boom", listed[0]["last_error"])
+	}
+
+	status, body := request(t, server, http.MethodGet, "/jobs/1", "")
+	assert.Equal(t, http.StatusOK, status)
+	if job, ok := body["job"].(map[string]any); assert.True(t, ok) {
+		assert.Equal(t, map[string]any{"tag": "#ABC"}, job["data"])
+	}
+	status, _ = request(t, server, http.MethodGet, "/jobs/99", "")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status, _ = request(t, server, http.MethodPost, "/jobs/1/trigger", "")
+	assert.Equal(t, http.StatusNoContent, status)
+	status, _ = request(t, server, http.MethodPost, "/jobs/2/trigger", "")
+	assert.Equal(t, http.StatusConflict, status)
+
+	status, _ = request(t, server, http.MethodPost, "/jobs/2/requeue", "")
+	assert.Equal(t, http.StatusNoContent, status)
+	status, _ = request(t, server, http.MethodPost, "/jobs/2/cancel", "")
+	assert.Equal(t, http.StatusNoContent, status)
+	status, _ = request(t, server, http.MethodPost, "/jobs/3/cancel", "")
+	assert.Equal(t, http.StatusNoContent, status)
+
+	status, body = request(t, server, http.MethodPost, "/kinds/update/Clan/trigger", `{"tag": "#DEF"}`)
+	assert.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, float64(4), body["id"])
+	status, _ = request(t, server, http.MethodPost, "/kinds/update/Clan/trigger", `not json`)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	for id, check := range map[int64]func(job *jobs.DBJob){
+		1: func(job *jobs.DBJob) { assert.Equal(t, jobs.JobStatePending, job.State) },
+		2: func(job *jobs.DBJob) { assert.Equal(t, jobs.JobStateFailed, job.State) },
+		3: func(job *jobs.DBJob) {
+			assert.Equal(t, jobs.JobStateRunning, job.State)
+			assert.True(t, job.CancelRequested)
+		},
+		4: func(job *jobs.DBJob) { assert.JSONEq(t, `{"tag": "#DEF"}`, job.Data) },
+	} {
+		job, err := jobs.GetJob(container.DB, id)
+		if assert.NoError(t, err) && assert.NotNil(t, job, "job %d", id) {
+			check(job)
+		}
+	}
+
+	var availableNow bool
+	if err := container.DB.GetContext(context.Background(), &availableNow, "SELECT available_at <= CURRENT_TIMESTAMP FROM jobs WHERE id = 1"); err != nil {
+		t.Fatalf("Could not read job: %v", err)
+	}
+	assert.True(t, availableNow, "Triggered job is not available")
+}
+
+type fakeKeyReporter struct{}
+
+func (fakeKeyReporter) KeysHealth() any {
+	return map[string]any{"healthy": 1, "keys": []map[string]string{{"name": "...abcdef", "state": "healthy"}}}
+}
+
+func TestKeyEndpoint(t *testing.T) {
+	t.Parallel()
+
+	server := admin.NewServer(nil, jobs.NewJobQueue(), fakeKeyReporter{}, testutil.MakeTestLogger().Logger, "secret")
+	status, body := request(t, server, http.MethodGet, "/keys", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, float64(1), body["healthy"])
+	assert.Len(t, body["keys"], 1)
+
+	server = admin.NewServer(nil, jobs.NewJobQueue(), nil, testutil.MakeTestLogger().Logger, "secret")
+	status, _ = request(t, server, http.MethodGet, "/keys", "")
+	assert.Equal(t, http.StatusNotFound, status)
+}
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Entry struct {
+	Url         string    `db:"url"`
+	StatusCode  int       `db:"status_code"`
+	ETag        string    `db:"etag"`
+	ContentType string    `db:"content_type"`
+	Body        []byte    `db:"body"`
+	ExpiresAt   time.Time `db:"expires_at"`
+}
+
+func (e *Entry) Fresh(now time.Time) bool {
+	return now.Before(e.ExpiresAt)
+}
+
+// Response builds a new response serving the cached body, so it can be consumed
+// the same way as one coming from the API.
+func (e *Entry) Response(request *http.Request) *http.Response {
+	header := make(http.Header)
+	if e.ContentType != "" {
+		header.Set("Content-Type", e.ContentType)
+	}
+	if e.ETag != "" {
+		header.Set("ETag", e.ETag)
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       request,
+	}
+}
+
+type Cache interface {
+	// Get returns nil without error when there is no entry for the url
+	Get(ctx context.Context, url string) (*Entry, error)
+	Put(ctx context.Context, entry *Entry) error
+}
+
+// MaxAge returns for how long a response can be cached according to its
+// Cache-Control header.
+func MaxAge(header http.Header) (time.Duration, bool) {
+	var maxAge time.Duration
+	found := false
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-store" || directive == "no-cache":
+			return 0, false
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil || seconds < 0 {
+				return 0, false
+			}
+			maxAge = time.Duration(seconds) * time.Second
+			found = true
+		}
+	}
+	return maxAge, found
+}
+
+type tieredCache struct {
+	tiers []Cache
+}
+
+// NewTieredCache looks entries up from the first tier to the last one, copying
+// entries found in slower tiers to the faster ones.
+func NewTieredCache(tiers ...Cache) Cache {
+	return &tieredCache{tiers: tiers}
+}
+
+func (c *tieredCache) Get(ctx context.Context, url string) (*Entry, error) {
+	var errs []error
+	for i, tier := range c.tiers {
+		entry, err := tier.Get(ctx, url)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if entry == nil {
+			continue
+		}
+		for j := 0; j < i; j++ {
+			if err := c.tiers[j].Put(ctx, entry); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return entry, errors.Join(errs...)
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (c *tieredCache) Put(ctx context.Context, entry *Entry) error {
+	var errs []error
+	for _, tier := range c.tiers {
+		if err := tier.Put(ctx, entry); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
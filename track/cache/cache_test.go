@@ -0,0 +1,105 @@
+package cache_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/MrNemo64/coc-tracker/track/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxAge(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		header   string
+		expected time.Duration
+		ok       bool
+	}{
+		{"max-age=600", 10 * time.Minute, true},
+		{"public, max-age=30", 30 * time.Second, true},
+		{"no-cache, max-age=30", 0, false},
+		{"max-age=abc", 0, false},
+		{"", 0, false},
+	} {
+		header := make(http.Header)
+		header.Set("Cache-Control", test.header)
+		maxAge, ok := cache.MaxAge(header)
+		assert.Equal(t, test.ok, ok, "Cache-Control: %s", test.header)
+		assert.Equal(t, test.expected, maxAge, "Cache-Control: %s", test.header)
+	}
+}
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	memory := cache.NewMemoryCache(2)
+
+	memory.Put(ctx, &cache.Entry{Url: "/a"})
+	memory.Put(ctx, &cache.Entry{Url: "/b"})
+	memory.Get(ctx, "/a")
+	memory.Put(ctx, &cache.Entry{Url: "/c"})
+
+	assert.Equal(t, 2, memory.Len())
+	for url, present := range map[string]bool{"/a": true, "/b": false, "/c": true} {
+		entry, err := memory.Get(ctx, url)
+		assert.NoError(t, err)
+		assert.Equal(t, present, entry != nil, "Entry %s", url)
+	}
+}
+
+func TestDisabledMemoryCache(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	memory := cache.NewMemoryCache(0)
+
+	assert.NoError(t, memory.Put(ctx, &cache.Entry{Url: "/a"}))
+	assert.Equal(t, 0, memory.Len())
+	entry, err := memory.Get(ctx, "/a")
+	assert.NoError(t, err)
+	assert.Nil(t, entry)
+}
+
+func TestTieredCachePromotesEntries(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	fast := cache.NewMemoryCache(10)
+	slow := cache.NewMemoryCache(10)
+	tiered := cache.NewTieredCache(fast, slow)
+
+	slow.Put(ctx, &cache.Entry{Url: "/a", StatusCode: 200, Body: []byte("{}")})
+
+	entry, err := tiered.Get(ctx, "/a")
+	assert.NoError(t, err)
+	assert.NotNil(t, entry)
+	assert.Equal(t, 1, fast.Len())
+
+	tiered.Put(ctx, &cache.Entry{Url: "/b"})
+	assert.Equal(t, 2, fast.Len())
+	assert.Equal(t, 2, slow.Len())
+}
+
+func TestEntryResponse(t *testing.T) {
+	t.Parallel()
+	entry := &cache.Entry{
+		Url:         "/a",
+		StatusCode:  200,
+		ETag:        "\"abc\"",
+		ContentType: "application/json",
+		Body:        []byte(`{"items":[]}`),
+		ExpiresAt:   time.Now().Add(time.Minute),
+	}
+
+	assert.True(t, entry.Fresh(time.Now()))
+	assert.False(t, entry.Fresh(time.Now().Add(2*time.Minute)))
+
+	response := entry.Response(nil)
+	body, err := io.ReadAll(response.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"items":[]}`, string(body))
+	assert.Equal(t, 200, response.StatusCode)
+	assert.Equal(t, "\"abc\"", response.Header.Get("ETag"))
+}
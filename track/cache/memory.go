@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+type MemoryCache struct {
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List
+	mu       sync.Mutex
+}
+
+// NewMemoryCache creates a cache that keeps up to capacity entries, evicting
+// the least recently used one when full. A capacity of 0 disables the cache.
+func NewMemoryCache(capacity int) *MemoryCache {
+	return &MemoryCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *MemoryCache) Get(_ context.Context, url string) (*Entry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[url]
+	if !ok {
+		return nil, nil
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*Entry), nil
+}
+
+func (c *MemoryCache) Put(_ context.Context, entry *Entry) error {
+	if c.capacity <= 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[entry.Url]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return nil
+	}
+
+	c.entries[entry.Url] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*Entry).Url)
+	}
+	return nil
+}
+
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type PostgresCache struct {
+	db *sqlx.DB
+}
+
+func NewPostgresCache(db *sqlx.DB) *PostgresCache {
+	return &PostgresCache{db: db}
+}
+
+func (c *PostgresCache) Get(ctx context.Context, url string) (*Entry, error) {
+	var entry Entry
+	err := c.db.GetContext(ctx, &entry, `
+	SELECT url, status_code, etag, content_type, body, expires_at
+	FROM api_cache
+	WHERE url = $1
+	`, url)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (c *PostgresCache) Put(ctx context.Context, entry *Entry) error {
+	_, err := c.db.NamedExecContext(ctx, `
+	INSERT INTO api_cache (url, status_code, etag, content_type, body, expires_at)
+	VALUES (:url, :status_code, :etag, :content_type, :body, :expires_at)
+	ON CONFLICT (url)
+	DO UPDATE SET
+		status_code = EXCLUDED.status_code,
+		etag = EXCLUDED.etag,
+		content_type = EXCLUDED.content_type,
+		body = EXCLUDED.body,
+		expires_at = EXCLUDED.expires_at,
+		updated_at = CURRENT_TIMESTAMP;
+	`, entry)
+	return err
+}
+
+// PruneExpired deletes the entries that expired before the given time.
+func PruneExpired(db *sqlx.DB, before time.Time) (int64, error) {
+	result, err := db.Exec("DELETE FROM api_cache WHERE expires_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
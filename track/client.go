@@ -1,17 +1,27 @@
 package track
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync/atomic"
 	"syscall"
+	"time"
 
+	"github.com/MrNemo64/coc-tracker/coc"
 	"github.com/MrNemo64/coc-tracker/db"
+	"github.com/MrNemo64/coc-tracker/track/admin"
+	"github.com/MrNemo64/coc-tracker/track/cache"
 	"github.com/MrNemo64/coc-tracker/track/jobs"
 	"github.com/MrNemo64/coc-tracker/track/jobs/update"
+	"github.com/MrNemo64/coc-tracker/track/portal"
 	"github.com/MrNemo64/coc-tracker/util"
 	"github.com/jmoiron/sqlx"
 )
@@ -24,48 +34,214 @@ type CocClient struct {
 	logger    *slog.Logger
 	db        *sqlx.DB
 	client    *http.Client
+	cache     cache.Cache
+	// How long running jobs are waited for when stopping
+	shutdownGracePeriod time.Duration
+	// Whether the API is in maintenance and jobs are held until it recovers
+	inMaintenance atomic.Bool
+	keyConf       KeySchedulerConfiguration
+	// Watched for changes, empty if keys are not loaded from a file
+	keysFile string
+	// Nil if keys are not managed through the developer portal
+	keyPortal      *portal.Manager
+	refreshingKeys atomic.Bool
+	// Empty if the admin API is not served
+	adminAddr  string
+	adminToken string
 }
 
+// How long to wait for the job loop past the grace period, for jobs that don't
+// react to their context being cancelled
+const jobStopMargin = 10 * time.Second
+
 func (c *CocClient) Get(ctx context.Context, url string) (response *http.Response, cacheHit bool, err error) {
 	cacheHit = false
 
-	response, err = c.client.Get(util.BaseUrl + url)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, util.BaseUrl+url, nil)
+	if err != nil {
+		return
+	}
+
+	cached, cacheErr := c.cache.Get(ctx, url)
+	if cacheErr != nil {
+		c.logger.Warn("Error reading response cache", "url", url, "err", cacheErr)
+	}
+	if cached != nil && cached.Fresh(time.Now()) {
+		return cached.Response(request), true, nil
+	}
+
+	if cached != nil && cached.ETag != "" {
+		request.Header.Set("If-None-Match", cached.ETag)
+	}
+
+	response, err = c.send(request)
+	if err != nil {
+		return
+	}
+
+	if response.StatusCode == http.StatusNotModified && cached != nil {
+		response.Body.Close()
+		revalidated := *cached
+		revalidated.ExpiresAt = time.Now()
+		if maxAge, ok := cache.MaxAge(response.Header); ok {
+			revalidated.ExpiresAt = revalidated.ExpiresAt.Add(maxAge)
+		}
+		c.storeInCache(ctx, &revalidated)
+		return revalidated.Response(request), true, nil
+	}
+
+	if response.StatusCode == http.StatusOK {
+		maxAge, ok := cache.MaxAge(response.Header)
+		if !ok {
+			return
+		}
+		body, readErr := io.ReadAll(response.Body)
+		response.Body.Close()
+		if readErr != nil {
+			return nil, false, readErr
+		}
+		response.Body = io.NopCloser(bytes.NewReader(body))
+		c.storeInCache(ctx, &cache.Entry{
+			Url:         url,
+			StatusCode:  response.StatusCode,
+			ETag:        response.Header.Get("ETag"),
+			ContentType: response.Header.Get("Content-Type"),
+			Body:        body,
+			ExpiresAt:   time.Now().Add(maxAge),
+		})
+	}
 
 	return
 }
 
+// Post sends a request that is never cached, for the endpoints that don't
+// just read data.
+func (c *CocClient) Post(ctx context.Context, url string, body io.Reader) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, util.BaseUrl+url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	return c.send(request)
+}
+
+// send authenticates a request with one of the keys, reacting to the errors
+// caused by the key or the API rather than by the request itself.
+func (c *CocClient) send(request *http.Request) (*http.Response, error) {
+	key, err := c.keys.Acquire(request.Context())
+	if err != nil {
+		return nil, err
+	}
+	return c.sendWith(request, key)
+}
+
+// sendWith sends a request with a key already reserved for it.
+func (c *CocClient) sendWith(request *http.Request, key *apiKey) (*http.Response, error) {
+	request.Header.Set("Authorization", "Bearer "+key.key)
+	request.Header.Set("Accept", "application/json")
+
+	start := time.Now()
+	response, err := c.client.Do(request)
+	latency := time.Since(start)
+	if err != nil {
+		c.keys.Release(key, 0, latency, err)
+		return nil, err
+	}
+	if response.StatusCode < 400 {
+		c.keys.Release(key, response.StatusCode, latency, nil)
+		return response, nil
+	}
+
+	apiErr := coc.ReadAPIError(response)
+	c.keys.Release(key, response.StatusCode, latency, apiErr)
+	switch {
+	case errors.Is(apiErr, coc.ErrInvalidIp):
+		c.refreshPortalKeys()
+	case errors.Is(apiErr, coc.ErrThrottled):
+		backoff := apiErr.RetryAfter
+		if backoff == 0 {
+			backoff = defaultThrottleBackoff
+		}
+		c.logger.Warn("API key throttled, backing off", "key", key.Name(), "backoff", backoff)
+		c.keys.Throttle(key, backoff)
+	case errors.Is(apiErr, coc.ErrMaintenance):
+		c.enterMaintenance()
+	}
+	return response, nil
+}
+
+func (c *CocClient) storeInCache(ctx context.Context, entry *cache.Entry) {
+	if err := c.cache.Put(ctx, entry); err != nil {
+		c.logger.Warn("Error storing response in cache", "url", entry.Url, "err", err)
+	}
+}
+
 func (c *CocClient) GetDB() *sqlx.DB {
 	return c.db
 }
 
+func (c *CocClient) BeginTx(ctx context.Context) (*jobs.JobTx, error) {
+	return c.jobs.BeginTx(ctx, c.db)
+}
+
 func CreateCocClient() *CocClient {
 	logger := util.GetLogger("client")
+	keyConf := KeySchedulerConfigurationFromEnv()
+	keys := &KeyList{logger: logger.With("name", "keys")}
 	keysFile := os.Getenv("KEYS_FILE")
-	if keysFile == "" {
-		panic("No keys file")
+	if keysFile != "" {
+		// An unreadable file is not fatal as long as other keys work
+		if specs, err := readKeysFile(keysFile); err != nil {
+			logger.Error("Error reading keys file", "file", keysFile, "err", err)
+		} else {
+			keys.Replace(keysFileSource, specs, keyConf)
+		}
 	}
 
-	keys, err := LoadKeysFromFile(keysFile)
-	if err != nil {
-		panic(err)
+	keyPortal := createKeyPortal(logger)
+	if keyPortal != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), portalTimeout)
+		tokens, err := keyPortal.EnsureKeys(ctx)
+		cancel()
+		if err != nil {
+			logger.Error("Error getting keys from the developer portal", "err", err)
+		} else {
+			added, _ := keys.Replace(portalKeysSource, tokenSpecs(tokens...), keyConf)
+			logger.Info("Loaded keys from the developer portal", "added", len(added))
+		}
 	}
 
-	if len(keys.keys) == 0 {
-		panic("No keys loaded")
+	if keys.Healthy() == 0 {
+		panic("No keys loaded, set KEYS_FILE or DEV_EMAIL and DEV_PASSWORD")
 	}
 
-	logger.Info(fmt.Sprintf("Loaded %d keys", len(keys.keys)))
+	logger.Info(fmt.Sprintf("Loaded %d keys", keys.Len()))
+
+	dbConf := db.DatabaseConfigurationFromEnv()
 
+	jobConf := jobs.JobLoopConfigurationFromEnv()
+	jobConf.NotifyDSN = dbConf.ConnectionString()
 	jobQueue := jobs.NewJobQueue()
+	jobQueue.Configure(jobConf)
 	addAllJobKinds(jobQueue)
 
-	db, err := db.ConnectToDatabase(db.DatabaseConfigurationFromEnv())
+	db, err := db.ConnectToDatabase(dbConf)
 	if err != nil {
 		panic(err)
 	}
 
 	logger.Info("Conected to database")
 
+	responseCache := createResponseCache(db)
+
+	// The admin API can pause, cancel and enqueue jobs, it is never served
+	// without authentication
+	adminAddr, adminToken := os.Getenv("ADMIN_ADDR"), os.Getenv("ADMIN_TOKEN")
+	if adminAddr != "" && adminToken == "" {
+		panic("ADMIN_TOKEN must be set to serve the admin API on ADMIN_ADDR")
+	}
+
 	logger.Info("Checking job status")
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -78,9 +254,37 @@ func CreateCocClient() *CocClient {
 		logger:    logger,
 		db:        db,
 		client:    &http.Client{},
+		cache:     responseCache,
+		keyConf:   keyConf,
+		keysFile:  keysFile,
+		keyPortal: keyPortal,
+
+		adminAddr:           adminAddr,
+		adminToken:          adminToken,
+		shutdownGracePeriod: jobConf.ShutdownGracePeriod,
 	}
 }
 
+func createResponseCache(db *sqlx.DB) cache.Cache {
+	size := 1000
+	if env := os.Getenv("CACHE_SIZE"); env != "" {
+		n, err := strconv.Atoi(env)
+		if err != nil {
+			panic(err)
+		}
+		if n < 0 {
+			panic(fmt.Errorf("CACHE_SIZE must be at least 0, got %d", n))
+		}
+		size = n
+	}
+
+	memory := cache.NewMemoryCache(size)
+	if os.Getenv("CACHE_DATABASE") != "true" {
+		return memory
+	}
+	return cache.NewTieredCache(memory, cache.NewPostgresCache(db))
+}
+
 func (client *CocClient) Run() {
 	sigChan := make(chan os.Signal, 1)
 
@@ -96,20 +300,68 @@ func (client *CocClient) Run() {
 		panic(err)
 	}
 
+	client.logger.Info("Checking keys")
+	if healthy := client.checkKeys(); healthy == 0 {
+		client.logger.Error("No healthy API keys, refusing to start")
+		panic("No healthy API keys")
+	}
+	go client.probeQuarantinedKeys()
+
 	client.logger.Info("Started tracker")
 	client.logger.Info("Starting tracker")
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	go client.jobs.RunJobLoop(client, client.logger.With("name", "job loop"), client.ctx)
+	loopDone := make(chan struct{})
+	go func() {
+		defer close(loopDone)
+		client.jobs.RunJobLoop(client, client.logger.With("name", "job loop"), client.ctx)
+	}()
+	if client.keysFile != "" {
+		go client.watchKeysFile(client.logger.With("name", "keys"))
+	}
+	adminServer := client.startAdminServer()
 	<-sigChan
 
-	client.logger.Info("Stopping tracker")
+	client.logger.Info("Stopping tracker, waiting for running jobs", "grace-period", client.shutdownGracePeriod)
+	if adminServer != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := adminServer.Shutdown(shutdownCtx); err != nil {
+			client.logger.Error("Error stopping admin server", "err", err)
+		}
+		cancel()
+	}
 	client.cancelCtx()
+	select {
+	case <-loopDone:
+	case <-time.After(client.shutdownGracePeriod + jobStopMargin):
+		// Jobs still running lose their lease and are reaped by the next instance
+		client.logger.Error("Job loop did not stop after the grace period, closing anyway")
+	}
 	if err := client.db.Close(); err != nil {
 		client.logger.Error("Error closing database connection", "err", err)
 	}
 	client.logger.Info("Stopped tracker")
 }
 
+// startAdminServer serves the admin API on ADMIN_ADDR, if set.
+func (client *CocClient) startAdminServer() *http.Server {
+	if client.adminAddr == "" {
+		return nil
+	}
+
+	logger := client.logger.With("name", "admin")
+	server := &http.Server{
+		Addr:    client.adminAddr,
+		Handler: admin.NewServer(client.db, client.jobs, client, logger, client.adminToken),
+	}
+	go func() {
+		logger.Info("Serving admin API", "addr", client.adminAddr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Error serving admin API", "err", err)
+		}
+	}()
+	return server
+}
+
 func addAllJobKinds(queue *jobs.RegisteredJobs) {
 	queue.RegisterJobKind(update.NewFetchCapitalLeaguesProvider())
 }
@@ -0,0 +1,101 @@
+package track
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/MrNemo64/coc-tracker/db"
+	"github.com/MrNemo64/coc-tracker/track/jobs"
+	"github.com/jmoiron/sqlx"
+)
+
+var commands = map[string]func(args []string) error{
+	"requeue": requeueCommand,
+	"pause":   pauseCommand,
+	"resume":  resumeCommand,
+}
+
+func requeueCommand(args []string) error {
+	flags := flag.NewFlagSet("requeue", flag.ContinueOnError)
+	name := flags.String("name", "", "only requeue failed jobs of this kind")
+	id := flags.Int64("id", 0, "requeue a single failed job")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := db.ConnectToDatabase(db.DatabaseConfigurationFromEnv())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if *id != 0 {
+		requeued, err := jobs.RequeueJob(db, *id)
+		if err != nil {
+			return err
+		}
+		if !requeued {
+			return fmt.Errorf("job %d does not exist or has not failed", *id)
+		}
+		fmt.Printf("Requeued job %d\n", *id)
+		return nil
+	}
+
+	count, err := jobs.RequeueFailedJobs(db, *name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Requeued %d jobs\n", count)
+	return nil
+}
+
+func pauseCommand(args []string) error {
+	flags := flag.NewFlagSet("pause", flag.ContinueOnError)
+	reason := flags.String("reason", "", "why the kinds are paused")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	return updateKinds("Paused", flags.Args(), func(queue *jobs.RegisteredJobs, db *sqlx.DB, pattern string) ([]string, error) {
+		return queue.PauseKinds(db, pattern, *reason)
+	})
+}
+
+func resumeCommand(args []string) error {
+	flags := flag.NewFlagSet("resume", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	return updateKinds("Resumed", flags.Args(), func(queue *jobs.RegisteredJobs, db *sqlx.DB, pattern string) ([]string, error) {
+		return queue.ResumeKinds(db, pattern)
+	})
+}
+
+// updateKinds applies an action to the job kinds matching each of the patterns.
+func updateKinds(done string, patterns []string, action func(queue *jobs.RegisteredJobs, db *sqlx.DB, pattern string) ([]string, error)) error {
+	if len(patterns) == 0 {
+		return errors.New("expected at least one job kind pattern, like update/*")
+	}
+
+	db, err := db.ConnectToDatabase(db.DatabaseConfigurationFromEnv())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	queue := jobs.NewJobQueue()
+	addAllJobKinds(queue)
+	for _, pattern := range patterns {
+		names, err := action(queue, db, pattern)
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("no registered job matches %q", pattern)
+		}
+		for _, name := range names {
+			fmt.Printf("%s %s\n", done, name)
+		}
+	}
+	return nil
+}
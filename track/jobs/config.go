@@ -0,0 +1,134 @@
+package jobs
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+type JobLoopConfiguration struct {
+	Workers int
+	// Maximum number of jobs claimed from the database at once
+	BatchSize int
+	// Identifies this tracker instance as the owner of the jobs it claims
+	InstanceId string
+	// How long a claimed job is reserved for this instance without a heartbeat
+	Lease time.Duration
+	// How often running jobs extend their lease
+	HeartbeatInterval time.Duration
+	// How often jobs with an expired lease are returned to pending
+	ReapInterval time.Duration
+	// Connection string used to listen for job notifications, polling is used when empty
+	NotifyDSN string
+	// Longest wait between fetches when not listening for notifications
+	PollInterval time.Duration
+	// Longest wait between fetches when listening, in case a notification is missed
+	ListenPollInterval time.Duration
+	// How long job runs are kept, forever if zero
+	RunRetention time.Duration
+	// Longest a job can run unless its provider declares a timeout, unbounded if zero
+	JobTimeout time.Duration
+	// How long running jobs are given to finish once the job loop is stopped
+	ShutdownGracePeriod time.Duration
+	// Consecutive failed runs after which a kind is paused, never if zero
+	PauseAfterFailures int
+}
+
+func DefaultJobLoopConfiguration() JobLoopConfiguration {
+	return JobLoopConfiguration{
+		Workers:             4,
+		BatchSize:           4,
+		InstanceId:          defaultInstanceId(),
+		Lease:               time.Minute,
+		HeartbeatInterval:   20 * time.Second,
+		ReapInterval:        time.Minute,
+		PollInterval:        time.Second,
+		ListenPollInterval:  time.Minute,
+		RunRetention:        30 * 24 * time.Hour,
+		JobTimeout:          5 * time.Minute,
+		ShutdownGracePeriod: 30 * time.Second,
+		PauseAfterFailures:  10,
+	}
+}
+
+func JobLoopConfigurationFromEnv() JobLoopConfiguration {
+	conf := DefaultJobLoopConfiguration()
+	if workers := os.Getenv("JOB_WORKERS"); workers != "" {
+		n, err := strconv.Atoi(workers)
+		if err != nil {
+			panic(err)
+		}
+		if n < 1 {
+			panic(fmt.Errorf("JOB_WORKERS must be at least 1, got %d", n))
+		}
+		conf.Workers = n
+		conf.BatchSize = n
+	}
+	if batch := os.Getenv("JOB_BATCH_SIZE"); batch != "" {
+		n, err := strconv.Atoi(batch)
+		if err != nil {
+			panic(err)
+		}
+		if n < 1 {
+			panic(fmt.Errorf("JOB_BATCH_SIZE must be at least 1, got %d", n))
+		}
+		conf.BatchSize = n
+	}
+	if failures := os.Getenv("JOB_PAUSE_AFTER_FAILURES"); failures != "" {
+		n, err := strconv.Atoi(failures)
+		if err != nil {
+			panic(err)
+		}
+		if n < 0 {
+			panic(fmt.Errorf("JOB_PAUSE_AFTER_FAILURES must not be negative, got %d", n))
+		}
+		conf.PauseAfterFailures = n
+	}
+	if instance := os.Getenv("INSTANCE_ID"); instance != "" {
+		conf.InstanceId = instance
+	}
+	durationFromEnv("JOB_LEASE", &conf.Lease)
+	durationFromEnv("JOB_HEARTBEAT_INTERVAL", &conf.HeartbeatInterval)
+	durationFromEnv("JOB_REAP_INTERVAL", &conf.ReapInterval)
+	durationFromEnv("JOB_POLL_INTERVAL", &conf.PollInterval)
+	durationFromEnv("JOB_LISTEN_POLL_INTERVAL", &conf.ListenPollInterval)
+	if retention := os.Getenv("JOB_RUN_RETENTION"); retention == "0" {
+		conf.RunRetention = 0
+	} else {
+		durationFromEnv("JOB_RUN_RETENTION", &conf.RunRetention)
+	}
+	if timeout := os.Getenv("JOB_TIMEOUT"); timeout == "0" {
+		conf.JobTimeout = 0
+	} else {
+		durationFromEnv("JOB_TIMEOUT", &conf.JobTimeout)
+	}
+	durationFromEnv("JOB_SHUTDOWN_GRACE_PERIOD", &conf.ShutdownGracePeriod)
+	if conf.HeartbeatInterval >= conf.Lease {
+		panic(fmt.Errorf("JOB_HEARTBEAT_INTERVAL (%s) must be shorter than JOB_LEASE (%s)", conf.HeartbeatInterval, conf.Lease))
+	}
+	return conf
+}
+
+func durationFromEnv(name string, target *time.Duration) {
+	value := os.Getenv(name)
+	if value == "" {
+		return
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		panic(fmt.Errorf("invalid duration in %s: %w", name, err))
+	}
+	if duration <= 0 {
+		panic(fmt.Errorf("%s must be positive, got %s", name, duration))
+	}
+	*target = duration
+}
+
+func defaultInstanceId() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
@@ -0,0 +1,140 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrJobCancelled = errors.New("job cancelled")
+
+type JobFilter struct {
+	// Only jobs of this kind, any kind if empty
+	Name string
+	// Only jobs in this state, any state if empty
+	State JobState
+	// Maximum number of jobs returned, all of them if zero
+	Limit  int
+	Offset int
+}
+
+// ListJobs returns the jobs matching the filter, in the order they were created.
+func ListJobs(db *sqlx.DB, filter JobFilter) ([]DBJob, error) {
+	var limit *int
+	if filter.Limit > 0 {
+		limit = &filter.Limit
+	}
+	jobs := []DBJob{}
+	err := db.Select(&jobs, `
+	SELECT *
+	FROM jobs
+	WHERE
+		($1::TEXT = '' OR name = $1::TEXT)
+		AND ($2::TEXT = '' OR state::TEXT = $2::TEXT)
+	ORDER BY id ASC
+	LIMIT $3::INTEGER
+	OFFSET $4
+	`, filter.Name, string(filter.State), limit, filter.Offset)
+	return jobs, err
+}
+
+// GetJob returns the job with the given id, or nil if it does not exist.
+func GetJob(db *sqlx.DB, id int64) (*DBJob, error) {
+	var job DBJob
+	err := db.Get(&job, "SELECT * FROM jobs WHERE id = $1", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// TriggerJob makes a pending job available right away.
+func TriggerJob(db *sqlx.DB, id int64) (bool, error) {
+	result, err := db.Exec(`
+	UPDATE jobs
+	SET available_at = CURRENT_TIMESTAMP
+	WHERE id = $1 AND state = 'pending'
+	`, id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// CancelJob fails a job that has not finished yet, along with the jobs that
+// depend on it. Running jobs are asked to stop through their context, right
+// away if they run in this instance or on their next heartbeat otherwise.
+func (q *RegisteredJobs) CancelJob(db *sqlx.DB, id int64) (bool, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+	UPDATE jobs
+	SET state = 'failed', last_error = $2, worker_id = NULL, locked_until = NULL
+	WHERE id = $1 AND state IN ('pending', 'queued', 'blocked')
+	`, id, ErrJobCancelled.Error())
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected == 1 {
+		if err := failDependents(tx, id); err != nil {
+			return false, err
+		}
+		return true, tx.Commit()
+	}
+
+	result, err = tx.Exec("UPDATE jobs SET cancel_requested = TRUE WHERE id = $1 AND state = 'running'", id)
+	if err != nil {
+		return false, err
+	}
+	if affected, err = result.RowsAffected(); err != nil || affected != 1 {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	q.mu.Lock()
+	cancel, ok := q.running[id]
+	q.mu.Unlock()
+	if ok {
+		cancel(ErrJobCancelled)
+	}
+	return true, nil
+}
+
+func (q *RegisteredJobs) trackRunning(id int64, cancel context.CancelCauseFunc) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.running[id] = cancel
+}
+
+func (q *RegisteredJobs) untrackRunning(id int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.running, id)
+}
+
+// JobNames returns the names of the registered job kinds, sorted.
+func (q *RegisteredJobs) JobNames() []string {
+	names := make([]string, 0, len(q.providers))
+	for name := range q.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,99 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// lockDependencies returns the jobs a new job has to wait for, locking them so
+// they can't finish before the dependencies are stored.
+func lockDependencies(ctx context.Context, tx *sqlx.Tx, dependsOn []int64, afterChildrenOf int64) ([]int64, error) {
+	if len(dependsOn) == 0 && afterChildrenOf == 0 {
+		return nil, nil
+	}
+
+	var dependencies []struct {
+		Id    int64    `db:"id"`
+		State JobState `db:"state"`
+	}
+	err := tx.SelectContext(ctx, &dependencies, `
+	SELECT id, state
+	FROM jobs
+	WHERE id = ANY($1) OR parent_id = $2
+	ORDER BY id
+	FOR SHARE
+	`, pq.Array(dependsOn), afterChildrenOf)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, 0, len(dependencies))
+	for _, dependency := range dependencies {
+		if dependency.State == JobStateFailed {
+			return nil, fmt.Errorf("dependency %d has failed", dependency.Id)
+		}
+		ids = append(ids, dependency.Id)
+	}
+	return ids, nil
+}
+
+func insertDependencies(ctx context.Context, tx *sqlx.Tx, id int64, dependsOn []int64) error {
+	_, err := tx.ExecContext(ctx, `
+	INSERT INTO job_dependencies (job_id, depends_on)
+	SELECT $1, unnest($2::BIGINT[])
+	`, id, pq.Array(dependsOn))
+	return err
+}
+
+// resolveDependencies marks a job as finished for the jobs waiting on it,
+// unblocking the ones that have nothing else to wait for.
+func resolveDependencies(tx *sqlx.Tx, id int64) error {
+	_, err := tx.Exec(`
+	WITH resolved AS (
+		DELETE FROM job_dependencies
+		WHERE depends_on = $1
+		RETURNING job_id
+	)
+	UPDATE jobs
+	SET state = 'pending'
+	WHERE
+		state = 'blocked'
+		AND id IN (SELECT job_id FROM resolved)
+		AND NOT EXISTS (
+			SELECT 1
+			FROM job_dependencies
+			WHERE job_id = jobs.id AND depends_on <> $1
+		)
+	`, id)
+	return err
+}
+
+// failDependents fails every blocked job that can no longer run because it
+// depends, directly or not, on a job that did not finish successfully.
+func failDependents(tx *sqlx.Tx, id int64) error {
+	_, err := tx.Exec(`
+	WITH RECURSIVE dependents AS (
+		SELECT job_id
+		FROM job_dependencies
+		WHERE depends_on = $1
+		UNION
+		SELECT job_dependencies.job_id
+		FROM job_dependencies
+		JOIN dependents ON job_dependencies.depends_on = dependents.job_id
+	)
+	UPDATE jobs
+	SET state = 'failed', last_error = $2
+	WHERE state = 'blocked' AND id IN (SELECT job_id FROM dependents)
+	`, id, fmt.Sprintf("dependency %d did not finish successfully", id))
+	return err
+}
+
+// ChildrenOf returns the jobs that still exist and were enqueued by the given job.
+func ChildrenOf(db *sqlx.DB, parentId int64) ([]DBJob, error) {
+	children := []DBJob{}
+	err := db.Select(&children, "SELECT * FROM jobs WHERE parent_id = $1 ORDER BY id", parentId)
+	return children, err
+}
@@ -0,0 +1,125 @@
+package jobs_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	testutil "github.com/MrNemo64/coc-tracker/test_util"
+	"github.com/MrNemo64/coc-tracker/track/jobs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobDependencies(t *testing.T) {
+	t.Parallel()
+
+	container, err := testutil.CreatePostgresContainer()
+	if err != nil {
+		t.Fatalf("Could not set up test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			t.Errorf("Error shuting down test container: %v", err)
+		}
+	})
+
+	readJob := func(id int64) jobs.DBJob {
+		var job jobs.DBJob
+		if err := container.DB.Get(&job, "SELECT * FROM jobs WHERE id = $1", id); err != nil {
+			t.Fatalf("Could not read job %d: %v", id, err)
+		}
+		return job
+	}
+
+	tx, err := container.DB.Beginx()
+	if err != nil {
+		t.Fatalf("Could not begin transaction: %v", err)
+	}
+	enqueue := func(name, step string, opts jobs.EnqueueOptions) int64 {
+		id, err := jobs.Enqueue(tx, name, fmt.Sprintf(`{"step": %q}`, step), opts)
+		if err != nil {
+			t.Fatalf("Error enqueueing %s: %v", step, err)
+		}
+		return id
+	}
+	clan := enqueue("mock/Step", "clan", jobs.EnqueueOptions{})
+	enqueue("mock/Step", "member-a", jobs.EnqueueOptions{ParentId: clan})
+	enqueue("mock/Step", "member-b", jobs.EnqueueOptions{ParentId: clan})
+	aggregate := enqueue("mock/Step", "aggregate", jobs.EnqueueOptions{AfterChildrenOf: clan})
+	failing := enqueue("mock/Fail", "fail", jobs.EnqueueOptions{})
+	afterFailing := enqueue("mock/Step", "after-fail", jobs.EnqueueOptions{DependsOn: []int64{failing}})
+	transitive := enqueue("mock/Step", "after-after-fail", jobs.EnqueueOptions{DependsOn: []int64{afterFailing}})
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Could not commit jobs: %v", err)
+	}
+
+	assert.Equal(t, jobs.JobStateBlocked, readJob(aggregate).State)
+	children, err := jobs.ChildrenOf(container.DB, clan)
+	assert.NoError(t, err)
+	assert.Len(t, children, 2)
+
+	steps := make(chan string, 10)
+	providers := jobs.NewJobQueue()
+	conf := jobs.DefaultJobLoopConfiguration()
+	conf.Workers = 1
+	providers.Configure(conf)
+	providers.RegisterJobKind(&mockJobProvider{
+		name: "mock/Step",
+		run: func(_ context.Context, data string) (*jobs.JobFinishInformation, error) {
+			steps <- data
+			return &jobs.JobFinishInformation{Successfull: true}, nil
+		},
+	})
+	providers.RegisterJobKind(&mockJobProvider{
+		name:  "mock/Fail",
+		retry: jobs.RetryPolicy{MaxAttempts: 1},
+		run: func(context.Context, string) (*jobs.JobFinishInformation, error) {
+			return nil, fmt.Errorf("boom")
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	loopDone := make(chan struct{})
+	go func() {
+		defer close(loopDone)
+		providers.RunJobLoop(&mockJobRunContext{db: container.DB}, testutil.MakeTestLogger().Logger, ctx)
+	}()
+
+	var ran []string
+	for len(ran) < 4 {
+		select {
+		case step := <-steps:
+			ran = append(ran, step)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for steps, ran %v", ran)
+		}
+	}
+	assert.Equal(t, `{"step": "aggregate"}`, ran[3])
+
+	assert.Eventually(t, func() bool {
+		return readJob(transitive).State == jobs.JobStateFailed
+	}, 5*time.Second, 100*time.Millisecond, "Dependents of a failed job were not failed")
+	cancel()
+	<-loopDone
+
+	select {
+	case step := <-steps:
+		t.Errorf("Job %s ran after its dependency failed", step)
+	default:
+	}
+	for _, id := range []int64{afterFailing, transitive} {
+		job := readJob(id)
+		assert.Equal(t, jobs.JobStateFailed, job.State)
+		assert.Equal(t, 0, job.Attempts)
+	}
+
+	tx, err = container.DB.Beginx()
+	if err != nil {
+		t.Fatalf("Could not begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	_, err = jobs.Enqueue(tx, "mock/Step", nil, jobs.EnqueueOptions{DependsOn: []int64{failing}})
+	assert.ErrorContains(t, err, "has failed")
+}
@@ -0,0 +1,234 @@
+package jobs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type ConflictStrategy int
+
+const (
+	// Keeps the existing job, moving it forward if the new one was due earlier
+	// and raising its priority if the new one had a higher priority
+	ConflictKeepEarliest ConflictStrategy = iota
+	// Replaces the schedule and priority of the existing job with the new ones
+	ConflictReplace
+	// Leaves the existing job untouched
+	ConflictSkip
+)
+
+type EnqueueOptions struct {
+	// When the job becomes available, now if zero
+	At time.Time
+	// Overrides the default priority of the job kind. The package level
+	// Enqueue doesn't know the registered kinds and uses 0 if not set.
+	Priority *int
+	// Prevents having two active jobs with the same name and data
+	Unique bool
+	// What to do when Unique is set and an equivalent job is already active.
+	// The existing job is only modified while it is pending or blocked.
+	OnConflict ConflictStrategy
+	// Job that created this one, 0 if none
+	ParentId int64
+	// Jobs that have to finish successfully before this one can run. Jobs
+	// that no longer exist are assumed to have finished already.
+	DependsOn []int64
+	// Makes the job a barrier that runs once every current child of the given
+	// job finished successfully, 0 if none
+	AfterChildrenOf int64
+}
+
+const uniqueKeyConflictTarget = `ON CONFLICT (unique_key)
+	WHERE unique_key IS NOT NULL AND state IN ('pending', 'queued', 'running', 'blocked')`
+
+// Enqueue inserts a new job and returns its id. The data is stored as is when
+// it is a string or []byte and marshalled to JSON otherwise. Jobs with
+// dependencies are blocked until all of them finish. For unique jobs that
+// conflict with an active one, the id of the active job is returned and the
+// dependencies are ignored. The defaults of the job kind, like its priority,
+// are not applied: use RegisteredJobs.Enqueue or JobTx.Enqueue for that.
+func Enqueue(tx *sqlx.Tx, name string, data any, opts EnqueueOptions) (int64, error) {
+	return enqueue(context.Background(), tx, name, data, opts)
+}
+
+func enqueue(ctx context.Context, tx *sqlx.Tx, name string, data any, opts EnqueueOptions) (int64, error) {
+	encoded, err := encodeJobData(data)
+	if err != nil {
+		return 0, err
+	}
+
+	at := opts.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+	priority := 0
+	if opts.Priority != nil {
+		priority = *opts.Priority
+	}
+	var parentId *int64
+	if opts.ParentId != 0 {
+		parentId = &opts.ParentId
+	}
+
+	dependencies, err := lockDependencies(ctx, tx, opts.DependsOn, opts.AfterChildrenOf)
+	if err != nil {
+		return 0, err
+	}
+	state := JobStatePending
+	if len(dependencies) > 0 {
+		state = JobStateBlocked
+	}
+
+	var key *string
+	var onConflict string
+	if opts.Unique {
+		uniqueKey, err := UniqueKey(name, encoded)
+		if err != nil {
+			return 0, err
+		}
+		key = &uniqueKey
+
+		switch opts.OnConflict {
+		case ConflictKeepEarliest:
+			onConflict = `DO UPDATE SET
+				available_at = LEAST(jobs.available_at, EXCLUDED.available_at),
+				priority = GREATEST(jobs.priority, EXCLUDED.priority)
+			WHERE jobs.state IN ('pending', 'blocked')`
+		case ConflictReplace:
+			onConflict = `DO UPDATE SET
+				available_at = EXCLUDED.available_at,
+				priority = EXCLUDED.priority
+			WHERE jobs.state IN ('pending', 'blocked')`
+		case ConflictSkip:
+			onConflict = "DO NOTHING"
+		default:
+			return 0, fmt.Errorf("unknown conflict strategy %d", opts.OnConflict)
+		}
+		onConflict = uniqueKeyConflictTarget + "\n" + onConflict
+	}
+
+	var rows []struct {
+		Id       int64 `db:"id"`
+		Inserted bool  `db:"inserted"`
+	}
+	err = tx.SelectContext(ctx, &rows, `
+	INSERT INTO jobs (name, data, available_at, priority, unique_key, state, parent_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`+onConflict+`
+	RETURNING id, xmax = 0 AS inserted
+	`, name, encoded, at, priority, key, state, parentId)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(rows) == 0 {
+		// Skipped, or the equivalent job is already running
+		var id int64
+		err = tx.GetContext(ctx, &id, `
+		SELECT id
+		FROM jobs
+		WHERE unique_key = $1 AND state IN ('pending', 'queued', 'running', 'blocked')
+		`, key)
+		return id, err
+	}
+
+	if rows[0].Inserted && len(dependencies) > 0 {
+		if err := insertDependencies(ctx, tx, rows[0].Id, dependencies); err != nil {
+			return 0, err
+		}
+	}
+	return rows[0].Id, nil
+}
+
+// UniqueKey identifies the jobs of a kind with equivalent data. The data is
+// normalized first, so the formatting and the order of the keys don't matter.
+func UniqueKey(name string, data any) (string, error) {
+	encoded, err := encodeJobData(data)
+	if err != nil {
+		return "", err
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(encoded))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return "", fmt.Errorf("error normalizing job data: %w", err)
+	}
+	normalized, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("error normalizing job data: %w", err)
+	}
+
+	hash := sha256.Sum256(normalized)
+	return name + ":" + hex.EncodeToString(hash[:]), nil
+}
+
+// Enqueue inserts a new pending job of a registered kind, using the defaults
+// declared by its provider for the options that are not set.
+func (q *RegisteredJobs) Enqueue(tx *sqlx.Tx, name string, data any, opts EnqueueOptions) (int64, error) {
+	return q.enqueue(context.Background(), tx, name, data, opts)
+}
+
+func (q *RegisteredJobs) enqueue(ctx context.Context, tx *sqlx.Tx, name string, data any, opts EnqueueOptions) (int64, error) {
+	provider := q.FindJobProvider(name)
+	if provider == nil {
+		return 0, fmt.Errorf("no provider registered for job %s", name)
+	}
+	if typed, ok := provider.(dataEnqueuer); ok {
+		return typed.enqueueData(ctx, tx, data, opts)
+	}
+	if opts.Priority == nil {
+		priority := defaultPriorityOf(provider)
+		opts.Priority = &priority
+	}
+	return enqueue(ctx, tx, name, data, opts)
+}
+
+// JobTx is a transaction that can enqueue jobs of the registered kinds. When
+// begun by a running job, the jobs it enqueues are children of that job.
+type JobTx struct {
+	*sqlx.Tx
+	ctx      context.Context
+	jobs     *RegisteredJobs
+	parentId int64
+}
+
+func (q *RegisteredJobs) BeginTx(ctx context.Context, db *sqlx.DB) (*JobTx, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &JobTx{Tx: tx, ctx: ctx, jobs: q}, nil
+}
+
+// Enqueue inserts a job that is only visible once the transaction commits.
+func (tx *JobTx) Enqueue(name string, data any, opts EnqueueOptions) (int64, error) {
+	if opts.ParentId == 0 {
+		opts.ParentId = tx.parentId
+	}
+	return tx.jobs.enqueue(tx.ctx, tx.Tx, name, data, opts)
+}
+
+func encodeJobData(data any) (string, error) {
+	switch data := data.(type) {
+	case nil:
+		return "{}", nil
+	case string:
+		return data, nil
+	case []byte:
+		return string(data), nil
+	default:
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return "", fmt.Errorf("error encoding job data: %w", err)
+		}
+		return string(encoded), nil
+	}
+}
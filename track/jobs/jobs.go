@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 type JobState string
@@ -17,11 +19,17 @@ var (
 	JobStatePending JobState = "pending"
 	JobStateQueued  JobState = "queued"
 	JobStateRunning JobState = "running"
+	JobStateFailed  JobState = "failed"
+	// Waiting for the jobs it depends on to finish
+	JobStateBlocked JobState = "blocked"
 )
 
 type JobRunContext interface {
 	GetDB() *sqlx.DB
 	Get(ctx context.Context, url string) (response *http.Response, cacheHit bool, err error)
+	// BeginTx starts a transaction that can also enqueue jobs, so a job can
+	// commit the data it wrote together with the jobs that follow from it.
+	BeginTx(ctx context.Context) (*JobTx, error)
 }
 
 type Job interface {
@@ -37,27 +45,56 @@ type ScheduleInformation struct {
 type JobFinishInformation struct {
 	Successfull bool
 	Reschedule  *ScheduleInformation
+	// Number of rows the job inserted or updated, kept in its run record
+	RowsWritten int64
 }
 
 type JobProvider interface {
 	Deserialize(string) (Job, error)
 	Save(*sqlx.Tx, *ScheduleInformation) error
-	CheckJobsTable(*sqlx.DB) error
 	JobName() string
 }
 
+// JobsTableChecker can be implemented by a JobProvider that needs to inspect or
+// seed the jobs table on startup. Recurring jobs should declare a Schedule instead.
+type JobsTableChecker interface {
+	CheckJobsTable(*sqlx.DB) error
+}
+
 type RegisteredJobs struct {
 	providers map[string]JobProvider
+	kindSlots map[string]chan struct{}
+	config    JobLoopConfiguration
+
+	mu sync.Mutex
+	// Kinds paused the last time jobs were claimed
+	paused map[string]bool
+	// Whether this instance claims no jobs at all
+	held bool
+	// Cancels the jobs running in this instance
+	running map[int64]context.CancelCauseFunc
 }
 
 func NewJobQueue() *RegisteredJobs {
 	return &RegisteredJobs{
 		providers: make(map[string]JobProvider),
+		kindSlots: make(map[string]chan struct{}),
+		config:    DefaultJobLoopConfiguration(),
+		paused:    make(map[string]bool),
+		running:   make(map[int64]context.CancelCauseFunc),
 	}
 }
 
+func (q *RegisteredJobs) Configure(conf JobLoopConfiguration) {
+	q.config = conf
+}
+
 func (q *RegisteredJobs) RegisterJobKind(provider JobProvider) {
 	q.providers[provider.JobName()] = provider
+	delete(q.kindSlots, provider.JobName())
+	if limit := maxConcurrencyOf(provider); limit > 0 {
+		q.kindSlots[provider.JobName()] = make(chan struct{}, limit)
+	}
 }
 
 func (q *RegisteredJobs) CheckJobs(db *sqlx.DB) error {
@@ -68,8 +105,15 @@ func (q *RegisteredJobs) CheckJobs(db *sqlx.DB) error {
 		wg.Add(1)
 		go func(name string, provider JobProvider) {
 			defer wg.Done()
-			if err := provider.CheckJobsTable(db); err != nil {
-				errCh <- fmt.Errorf("error in provider %s: %w", name, err)
+			if schedule := scheduleOf(provider); schedule != nil {
+				if err := ensureScheduled(db, provider, schedule); err != nil {
+					errCh <- fmt.Errorf("error scheduling provider %s: %w", name, err)
+				}
+			}
+			if checker, ok := provider.(JobsTableChecker); ok {
+				if err := checker.CheckJobsTable(db); err != nil {
+					errCh <- fmt.Errorf("error in provider %s: %w", name, err)
+				}
 			}
 		}(name, provider)
 	}
@@ -98,13 +142,17 @@ func (q *RegisteredJobs) FindJobProvider(name string) JobProvider {
 	return provider
 }
 
-func (q *RegisteredJobs) RunJobLoop(jctx JobRunContext, logger *slog.Logger, ctx context.Context) {
-
-}
-
 func (q *RegisteredJobs) FetchAvailableJobs(jctx JobRunContext, logger *slog.Logger, ctx context.Context, consumers chan DBJob) error {
+	listener := q.listenForJobs(logger)
+	if listener != nil {
+		defer listener.Close()
+	}
+
+	fetchNow := true
 	for {
-		time.Sleep(time.Second * 1)
+		if !fetchNow {
+			q.waitForJobs(ctx, jctx.GetDB(), logger, listener)
+		}
 		select {
 		case <-ctx.Done():
 			ids := collectJobsFromChannel(consumers)
@@ -114,40 +162,20 @@ func (q *RegisteredJobs) FetchAvailableJobs(jctx JobRunContext, logger *slog.Log
 			return setJobsToPending(ids, logger, jctx.GetDB())
 		default:
 		}
-		rows, err := jctx.GetDB().QueryxContext(ctx, `
-		WITH selected_jobs AS (
-			SELECT id
-			FROM jobs
-			WHERE
-				state = 'pending'
-				AND available_at <= CURRENT_TIMESTAMP
-			ORDER BY available_at ASC
-		)
-		UPDATE jobs
-		SET state = 'queued'
-		FROM selected_jobs
-		WHERE jobs.id = selected_jobs.id
-		RETURNING *;
-		`)
-		if err != nil {
-			logger.Error("Error fetching available jobs", "err", err)
-			continue
+		limit := min(q.config.BatchSize, cap(consumers)-len(consumers))
+		if limit < 1 {
+			limit = 1
 		}
-
-		var jobs []DBJob
-
-		for rows.Next() {
-			var job DBJob
-			if err = rows.StructScan(&job); err != nil {
-				logger.Error("Error scanning row", "err", err)
-				continue
+		jobs, err := q.claimJobs(ctx, jctx.GetDB(), limit)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Error("Error fetching available jobs", "err", err)
 			}
-			jobs = append(jobs, job)
+			fetchNow = false
+			continue
 		}
 
-		if err := rows.Close(); err != nil {
-			logger.Error("Error closing rows", "err", err)
-		}
+		fetchNow = len(jobs) == limit
 
 		for i := 0; i < len(jobs); i++ {
 			job := jobs[i]
@@ -168,6 +196,101 @@ func (q *RegisteredJobs) FetchAvailableJobs(jctx JobRunContext, logger *slog.Log
 	}
 }
 
+// claimJobs marks up to limit available jobs as queued by this instance and
+// returns them sorted by priority. Jobs of kinds with a concurrency limit are
+// only claimed while the kind has room for them across all instances, and jobs
+// of paused kinds are not claimed at all, nor any job while the queue is held.
+func (q *RegisteredJobs) claimJobs(ctx context.Context, db *sqlx.DB, limit int) ([]DBJob, error) {
+	if q.IsHeld() {
+		return nil, nil
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	names, limits := q.concurrencyLimits()
+	if len(names) > 0 {
+		// Counting the running jobs of a kind and claiming more has to be atomic between instances
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('jobs/claim'))"); err != nil {
+			return nil, err
+		}
+	}
+
+	paused, err := q.loadPausedKinds(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []DBJob
+	err = tx.SelectContext(ctx, &jobs, `
+	WITH active_jobs AS (
+		SELECT name, COUNT(*) AS active
+		FROM jobs
+		WHERE state IN ('queued', 'running')
+		GROUP BY name
+	), limits AS (
+		SELECT *
+		FROM unnest($4::varchar[], $5::bigint[]) AS limits(name, max_active)
+	), ranked_jobs AS (
+		SELECT
+			id,
+			name,
+			ROW_NUMBER() OVER (PARTITION BY name ORDER BY priority DESC, available_at ASC) AS position
+		FROM jobs
+		WHERE
+			state = 'pending'
+			AND available_at <= CURRENT_TIMESTAMP
+			AND name = ANY($4::varchar[])
+	), selected_jobs AS (
+		SELECT id
+		FROM jobs
+		WHERE
+			state = 'pending'
+			AND available_at <= CURRENT_TIMESTAMP
+			AND name <> ALL($6::varchar[])
+			AND (
+				name <> ALL($4::varchar[])
+				OR id IN (
+					SELECT ranked_jobs.id
+					FROM ranked_jobs
+					JOIN limits ON limits.name = ranked_jobs.name
+					LEFT JOIN active_jobs ON active_jobs.name = ranked_jobs.name
+					WHERE ranked_jobs.position + COALESCE(active_jobs.active, 0) <= limits.max_active
+				)
+			)
+		ORDER BY priority DESC, available_at ASC
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	)
+	UPDATE jobs
+	SET
+		state = 'queued',
+		worker_id = $1,
+		locked_until = CURRENT_TIMESTAMP + make_interval(secs => $2)
+	FROM selected_jobs
+	WHERE jobs.id = selected_jobs.id
+	RETURNING jobs.*;
+	`, q.config.InstanceId, q.config.Lease.Seconds(), limit, pq.Array(names), pq.Array(limits), pq.Array(paused))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].Priority != jobs[j].Priority {
+			return jobs[i].Priority > jobs[j].Priority
+		}
+		return jobs[i].AvailableAt.Before(jobs[j].AvailableAt)
+	})
+	return jobs, nil
+}
+
 func collectJobsFromChannel(channel chan DBJob) []int64 {
 	var ids []int64
 	for {
@@ -181,7 +304,7 @@ func collectJobsFromChannel(channel chan DBJob) []int64 {
 }
 
 func setJobsToPending(ids []int64, logger *slog.Logger, db *sqlx.DB) error {
-	query, args, err := sqlx.In("UPDATE jobs SET state = 'pending' WHERE id IN (?)", ids)
+	query, args, err := sqlx.In("UPDATE jobs SET state = 'pending', worker_id = NULL, locked_until = NULL WHERE id IN (?) AND state IN ('queued', 'running')", ids)
 	if err != nil {
 		logger.Error("Error preparing update query to revert jobs to pending", "err", err, "jobs", ids)
 		return err
@@ -197,10 +320,19 @@ func setJobsToPending(ids []int64, logger *slog.Logger, db *sqlx.DB) error {
 }
 
 type DBJob struct {
-	Id          int64     `db:"id"`
-	Name        string    `db:"name"`
-	Data        string    `db:"data"`
-	State       JobState  `db:"state"`
-	CreatedAt   time.Time `db:"created_at"`
-	AvailableAt time.Time `db:"available_at"`
+	Id          int64      `db:"id"`
+	Name        string     `db:"name"`
+	Data        string     `db:"data"`
+	State       JobState   `db:"state"`
+	CreatedAt   time.Time  `db:"created_at"`
+	AvailableAt time.Time  `db:"available_at"`
+	Attempts    int        `db:"attempts"`
+	LastError   *string    `db:"last_error"`
+	LockedUntil *time.Time `db:"locked_until"`
+	WorkerId    *string    `db:"worker_id"`
+	Priority    int        `db:"priority"`
+	UniqueKey   *string    `db:"unique_key"`
+	ParentId    *int64     `db:"parent_id"`
+	// Set when the job was cancelled while running, until the run stops
+	CancelRequested bool `db:"cancel_requested"`
 }
@@ -21,11 +21,22 @@ func TestMain(m *testing.M) {
 }
 
 type mockJobRunContext struct {
-	db *sqlx.DB
+	db    *sqlx.DB
+	get   func(url string) (*http.Response, bool, error)
+	queue *jobs.RegisteredJobs
 }
 
 func (m *mockJobRunContext) GetDB() *sqlx.DB { return m.db }
-func (m *mockJobRunContext) Get(context.Context, string) (*http.Response, bool, error) {
+func (m *mockJobRunContext) BeginTx(ctx context.Context) (*jobs.JobTx, error) {
+	if m.queue == nil {
+		return jobs.NewJobQueue().BeginTx(ctx, m.db)
+	}
+	return m.queue.BeginTx(ctx, m.db)
+}
+func (m *mockJobRunContext) Get(_ context.Context, url string) (*http.Response, bool, error) {
+	if m.get != nil {
+		return m.get(url)
+	}
 	return nil, false, fmt.Errorf("not implemented")
 }
 
@@ -119,8 +130,10 @@ func TestFetchAvailableJobs(t *testing.T) {
 			errChanel <- providers.FetchAvailableJobs(jctx, logger.Logger, ctx, jobChannel)
 		}()
 
+		var claimed jobs.DBJob
 		select {
 		case job := <-jobChannel:
+			claimed = job
 			if _, err := container.DB.Exec(`UPDATE jobs SET state = $1 WHERE id = $2`, jobs.JobStateRunning, job.Id); err != nil {
 				t.Errorf("Could not update state of extracted job: %v", err)
 			}
@@ -154,6 +167,8 @@ func TestFetchAvailableJobs(t *testing.T) {
 		}
 
 		mockJobs[0].State = jobs.JobStateRunning
+		mockJobs[0].WorkerId = claimed.WorkerId
+		mockJobs[0].LockedUntil = claimed.LockedUntil
 		container.AssertJobsTableEquals(t, mockJobs)
 	})
 
@@ -174,9 +189,11 @@ func TestFetchAvailableJobs(t *testing.T) {
 		}()
 
 		expectedOrder := []int64{1, 3, 4, 2}
+		claimed := make(map[int64]jobs.DBJob)
 		for i := 0; i < 4; i++ {
 			select {
 			case job := <-jobChannel:
+				claimed[job.Id] = job
 				if _, err := container.DB.Exec(`UPDATE jobs SET state = $1 WHERE id = $2`, jobs.JobStateRunning, job.Id); err != nil {
 					t.Errorf("Could not update state of extracted job: %v", err)
 				}
@@ -217,10 +234,860 @@ func TestFetchAvailableJobs(t *testing.T) {
 			t.Errorf("Jobs channel is not empty after cancelation, found %v", foundJobs)
 		}
 
-		mockJobs[0].State = jobs.JobStateRunning
-		mockJobs[2].State = jobs.JobStateRunning
-		mockJobs[3].State = jobs.JobStateRunning
-		mockJobs[1].State = jobs.JobStateRunning
+		for i := 0; i < 4; i++ {
+			mockJobs[i].State = jobs.JobStateRunning
+			mockJobs[i].WorkerId = claimed[mockJobs[i].Id].WorkerId
+			mockJobs[i].LockedUntil = claimed[mockJobs[i].Id].LockedUntil
+		}
 		container.AssertJobsTableEquals(t, mockJobs)
 	})
 }
+
+type mockJob struct {
+	provider *mockJobProvider
+	data     string
+}
+
+func (j *mockJob) Run(_ jobs.JobRunContext, ctx context.Context) (*jobs.JobFinishInformation, error) {
+	return j.provider.run(ctx, j.data)
+}
+
+func (j *mockJob) Serialize(*sqlx.DB) error { return nil }
+
+type mockJobProvider struct {
+	name  string
+	run   func(ctx context.Context, data string) (*jobs.JobFinishInformation, error)
+	retry jobs.RetryPolicy
+}
+
+func (p *mockJobProvider) Deserialize(data string) (jobs.Job, error) {
+	return &mockJob{provider: p, data: data}, nil
+}
+
+func (p *mockJobProvider) Save(tx *sqlx.Tx, info *jobs.ScheduleInformation) error {
+	_, err := tx.Exec("INSERT INTO jobs (name, available_at) VALUES ($1, $2)", p.name, info.At)
+	return err
+}
+
+func (p *mockJobProvider) CheckJobsTable(*sqlx.DB) error { return nil }
+func (p *mockJobProvider) JobName() string               { return p.name }
+func (p *mockJobProvider) RetryPolicy() jobs.RetryPolicy { return p.retry }
+
+func TestRunJobLoop(t *testing.T) {
+	t.Parallel()
+
+	container, err := testutil.CreatePostgresContainer()
+	if err != nil {
+		t.Fatalf("Could not set up test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			t.Errorf("Error shuting down test container: %v", err)
+		}
+	})
+
+	for _, name := range []string{"mock/Done", "mock/Reschedule"} {
+		if _, err := container.DB.Exec("INSERT INTO jobs (name) VALUES ($1)", name); err != nil {
+			t.Fatalf("Error inserting job %s: %v", name, err)
+		}
+	}
+
+	ran := make(chan string, 2)
+	rescheduleAt := time.Now().Add(time.Hour)
+	providers := jobs.NewJobQueue()
+	conf := jobs.DefaultJobLoopConfiguration()
+	conf.Workers = 2
+	providers.Configure(conf)
+	providers.RegisterJobKind(&mockJobProvider{
+		name: "mock/Done",
+		run: func(context.Context, string) (*jobs.JobFinishInformation, error) {
+			ran <- "mock/Done"
+			return &jobs.JobFinishInformation{Successfull: true}, nil
+		},
+	})
+	providers.RegisterJobKind(&mockJobProvider{
+		name: "mock/Reschedule",
+		run: func(context.Context, string) (*jobs.JobFinishInformation, error) {
+			ran <- "mock/Reschedule"
+			return &jobs.JobFinishInformation{
+				Successfull: true,
+				Reschedule:  &jobs.ScheduleInformation{At: rescheduleAt},
+			}, nil
+		},
+	})
+
+	logger := testutil.MakeTestLogger()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	loopDone := make(chan struct{})
+	go func() {
+		defer close(loopDone)
+		providers.RunJobLoop(&mockJobRunContext{db: container.DB}, logger.Logger, ctx)
+	}()
+
+	var names []string
+	for i := 0; i < 2; i++ {
+		select {
+		case name := <-ran:
+			names = append(names, name)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for job %d to run", i)
+		}
+	}
+	ranAt := time.Now()
+	assert.ElementsMatch(t, []string{"mock/Done", "mock/Reschedule"}, names)
+
+	// Runs are recorded once the worker saved the state of the job
+	waitForJobRuns(t, container.DB, 2)
+	assert.Eventually(t, func() bool {
+		var pending int
+		container.DB.Get(&pending, "SELECT COUNT(*) FROM jobs WHERE state = 'pending' AND name = 'mock/Reschedule'")
+		return pending == 1
+	}, 5*time.Second, 20*time.Millisecond, "The rescheduled job was not saved")
+	cancel()
+	select {
+	case <-loopDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for RunJobLoop to stop")
+	}
+
+	container.AssertJobsTableEquals(t, []jobs.DBJob{
+		{
+			Id:          3,
+			Name:        "mock/Reschedule",
+			Data:        "{}",
+			State:       jobs.JobStatePending,
+			CreatedAt:   ranAt,
+			AvailableAt: rescheduleAt,
+		},
+	})
+}
+
+// waitForJobRuns waits until the given number of runs were recorded. Workers
+// record a run after saving the state of its job.
+func waitForJobRuns(t *testing.T, db *sqlx.DB, count int) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		var runs int
+		return db.Get(&runs, "SELECT COUNT(*) FROM job_runs") == nil && runs >= count
+	}, 5*time.Second, 20*time.Millisecond, "Timed out waiting for %d job runs", count)
+}
+
+func TestJobRetries(t *testing.T) {
+	t.Parallel()
+
+	container, err := testutil.CreatePostgresContainer()
+	if err != nil {
+		t.Fatalf("Could not set up test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			t.Errorf("Error shuting down test container: %v", err)
+		}
+	})
+
+	if _, err := container.DB.Exec("INSERT INTO jobs (name) VALUES ('mock/Fail')"); err != nil {
+		t.Fatalf("Error inserting job: %v", err)
+	}
+
+	ran := make(chan struct{}, 2)
+	providers := jobs.NewJobQueue()
+	providers.RegisterJobKind(&mockJobProvider{
+		name: "mock/Fail",
+		run: func(context.Context, string) (*jobs.JobFinishInformation, error) {
+			ran <- struct{}{}
+			return nil, fmt.Errorf("boom")
+		},
+		retry: jobs.RetryPolicy{
+			MaxAttempts: 2,
+			BaseDelay:   10 * time.Millisecond,
+			MaxDelay:    10 * time.Millisecond,
+		},
+	})
+
+	logger := testutil.MakeTestLogger()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	loopDone := make(chan struct{})
+	go func() {
+		defer close(loopDone)
+		providers.RunJobLoop(&mockJobRunContext{db: container.DB}, logger.Logger, ctx)
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-ran:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for attempt %d", i+1)
+		}
+	}
+
+	waitForJobRuns(t, container.DB, 2)
+	assert.Eventually(t, func() bool {
+		job, err := jobs.GetJob(container.DB, 1)
+		return err == nil && job != nil && job.State == jobs.JobStateFailed
+	}, 5*time.Second, 20*time.Millisecond, "The job was not failed")
+	cancel()
+	select {
+	case <-loopDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for RunJobLoop to stop")
+	}
+
+	var failed jobs.DBJob
+	if err := container.DB.Get(&failed, "SELECT * FROM jobs WHERE id = 1"); err != nil {
+		t.Fatalf("Could not read job: %v", err)
+	}
+	assert.Equal(t, jobs.JobStateFailed, failed.State)
+	assert.Equal(t, 2, failed.Attempts)
+	if assert.NotNil(t, failed.LastError) {
+		assert.Equal(t, "boom", *failed.LastError)
+	}
+
+	requeued, err := jobs.RequeueFailedJobs(container.DB, "mock/Fail")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), requeued)
+
+	var pending jobs.DBJob
+	if err := container.DB.Get(&pending, "SELECT * FROM jobs WHERE id = 1"); err != nil {
+		t.Fatalf("Could not read job: %v", err)
+	}
+	assert.Equal(t, jobs.JobStatePending, pending.State)
+	assert.Equal(t, 0, pending.Attempts)
+}
+
+func TestStopAndDeferJobs(t *testing.T) {
+	t.Parallel()
+
+	container, err := testutil.CreatePostgresContainer()
+	if err != nil {
+		t.Fatalf("Could not set up test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			t.Errorf("Error shuting down test container: %v", err)
+		}
+	})
+
+	for _, name := range []string{"mock/Stop", "mock/Defer"} {
+		if _, err := container.DB.Exec("INSERT INTO jobs (name) VALUES ($1)", name); err != nil {
+			t.Fatalf("Error inserting job %s: %v", name, err)
+		}
+	}
+
+	ran := make(chan string, 2)
+	providers := jobs.NewJobQueue()
+	conf := jobs.DefaultJobLoopConfiguration()
+	conf.PauseAfterFailures = 1
+	providers.Configure(conf)
+	providers.RegisterJobKind(&mockJobProvider{
+		name: "mock/Stop",
+		run: func(context.Context, string) (*jobs.JobFinishInformation, error) {
+			ran <- "mock/Stop"
+			return nil, jobs.StopJob(fmt.Errorf("player not found"))
+		},
+	})
+	providers.RegisterJobKind(&mockJobProvider{
+		name: "mock/Defer",
+		run: func(context.Context, string) (*jobs.JobFinishInformation, error) {
+			ran <- "mock/Defer"
+			return nil, jobs.RetryAfter(fmt.Errorf("in maintenance"), time.Hour)
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	loopDone := make(chan struct{})
+	go func() {
+		defer close(loopDone)
+		providers.RunJobLoop(&mockJobRunContext{db: container.DB}, testutil.MakeTestLogger().Logger, ctx)
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-ran:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for job %d to run", i+1)
+		}
+	}
+	waitForJobRuns(t, container.DB, 2)
+	assert.Eventually(t, func() bool {
+		var saved int
+		container.DB.Get(&saved, "SELECT COUNT(*) FROM jobs WHERE (id = 1 AND state = 'failed') OR (id = 2 AND state = 'pending')")
+		return saved == 2
+	}, 5*time.Second, 20*time.Millisecond, "The stopped and deferred jobs were not saved")
+	cancel()
+	select {
+	case <-loopDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for RunJobLoop to stop")
+	}
+
+	stopped, err := jobs.GetJob(container.DB, 1)
+	if assert.NoError(t, err) && assert.NotNil(t, stopped) {
+		assert.Equal(t, jobs.JobStateFailed, stopped.State)
+		assert.Equal(t, 1, stopped.Attempts)
+	}
+	deferred, err := jobs.GetJob(container.DB, 2)
+	if assert.NoError(t, err) && assert.NotNil(t, deferred) {
+		assert.Equal(t, jobs.JobStatePending, deferred.State)
+		assert.Equal(t, 0, deferred.Attempts, "Deferred runs don't use up attempts")
+		assert.WithinDuration(t, time.Now().Add(time.Hour), deferred.AvailableAt, time.Minute)
+	}
+
+	runs, err := jobs.ListJobRuns(container.DB, jobs.JobRunFilter{})
+	assert.NoError(t, err)
+	outcomes := map[string]jobs.JobRunOutcome{}
+	for _, run := range runs {
+		outcomes[run.Name] = run.Outcome
+	}
+	assert.Equal(t, map[string]jobs.JobRunOutcome{"mock/Stop": jobs.JobRunStopped, "mock/Defer": jobs.JobRunDeferred}, outcomes)
+	assert.False(t, providers.IsPaused("mock/Stop"), "Stopped runs don't pause the kind")
+	assert.False(t, providers.IsPaused("mock/Defer"), "Deferred runs don't pause the kind")
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	t.Parallel()
+	policy := jobs.RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	for attempts, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second} {
+		for i := 0; i < 20; i++ {
+			delay := policy.Backoff(attempts)
+			assert.GreaterOrEqual(t, delay, expected/2, "attempt %d", attempts)
+			assert.LessOrEqual(t, delay, expected, "attempt %d", attempts)
+		}
+	}
+}
+
+func TestConcurrentJobClaiming(t *testing.T) {
+	t.Parallel()
+
+	container, err := testutil.CreatePostgresContainer()
+	if err != nil {
+		t.Fatalf("Could not set up test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			t.Errorf("Error shuting down test container: %v", err)
+		}
+	})
+
+	const jobCount = 30
+	for i := 0; i < jobCount; i++ {
+		if _, err := container.DB.Exec("INSERT INTO jobs (name) VALUES ($1)", fmt.Sprintf("Job %d", i)); err != nil {
+			t.Fatalf("Error inserting job %d: %v", i, err)
+		}
+	}
+
+	type claim struct {
+		instance string
+		job      jobs.DBJob
+	}
+	claims := make(chan claim, jobCount*2)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	errChannel := make(chan error, 2)
+	for _, instance := range []string{"instance-a", "instance-b"} {
+		conf := jobs.DefaultJobLoopConfiguration()
+		conf.InstanceId = instance
+		conf.BatchSize = 3
+		providers := jobs.NewJobQueue()
+		providers.Configure(conf)
+		jobChannel := make(chan jobs.DBJob, 3)
+
+		go func(instance string) {
+			for {
+				select {
+				case job := <-jobChannel:
+					claims <- claim{instance: instance, job: job}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(instance)
+		go func() {
+			errChannel <- providers.FetchAvailableJobs(&mockJobRunContext{db: container.DB}, testutil.MakeTestLogger().Logger, ctx, jobChannel)
+		}()
+	}
+
+	owners := make(map[int64]string)
+	for len(owners) < jobCount {
+		select {
+		case c := <-claims:
+			if previous, ok := owners[c.job.Id]; ok {
+				t.Fatalf("Job %d claimed by both %s and %s", c.job.Id, previous, c.instance)
+			}
+			owners[c.job.Id] = c.instance
+			if assert.NotNil(t, c.job.WorkerId) {
+				assert.Equal(t, c.instance, *c.job.WorkerId)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timed out waiting for jobs, only %d claimed", len(owners))
+		}
+	}
+
+	cancel()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errChannel:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for FetchAvailableJobs to cancel")
+		}
+	}
+
+	select {
+	case c := <-claims:
+		t.Errorf("Job %d claimed twice", c.job.Id)
+	default:
+	}
+}
+
+func TestFetchAvailableJobsWakesUpOnNotification(t *testing.T) {
+	t.Parallel()
+
+	container, err := testutil.CreatePostgresContainer()
+	if err != nil {
+		t.Fatalf("Could not set up test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			t.Errorf("Error shuting down test container: %v", err)
+		}
+	})
+
+	conf := jobs.DefaultJobLoopConfiguration()
+	conf.NotifyDSN = container.ConnectionString
+	conf.ListenPollInterval = time.Hour
+	providers := jobs.NewJobQueue()
+	providers.Configure(conf)
+
+	jobChannel := make(chan jobs.DBJob, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	errChannel := make(chan error, 1)
+	go func() {
+		errChannel <- providers.FetchAvailableJobs(&mockJobRunContext{db: container.DB}, testutil.MakeTestLogger().Logger, ctx, jobChannel)
+	}()
+
+	// Let the loop find nothing and go to sleep before inserting the job
+	time.Sleep(500 * time.Millisecond)
+	if _, err := container.DB.Exec("INSERT INTO jobs (name) VALUES ('Job 1')"); err != nil {
+		t.Fatalf("Error inserting job: %v", err)
+	}
+
+	select {
+	case job := <-jobChannel:
+		assert.Equal(t, "Job 1", job.Name)
+	case <-time.After(2 * time.Second):
+		t.Error("Timed out waiting for the notified job")
+	}
+
+	cancel()
+	select {
+	case err := <-errChannel:
+		assert.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Error("Timed out waiting for FetchAvailableJobs to cancel")
+	}
+}
+
+type limitedMockJobProvider struct {
+	mockJobProvider
+	priority       int
+	maxConcurrency int
+}
+
+func (p *limitedMockJobProvider) DefaultPriority() int { return p.priority }
+func (p *limitedMockJobProvider) MaxConcurrency() int  { return p.maxConcurrency }
+
+func TestClaimPriorityAndConcurrencyLimits(t *testing.T) {
+	t.Parallel()
+
+	container, err := testutil.CreatePostgresContainer()
+	if err != nil {
+		t.Fatalf("Could not set up test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			t.Errorf("Error shuting down test container: %v", err)
+		}
+	})
+
+	providers := jobs.NewJobQueue()
+	providers.RegisterJobKind(&limitedMockJobProvider{mockJobProvider: mockJobProvider{name: "mock/Low"}})
+	providers.RegisterJobKind(&limitedMockJobProvider{mockJobProvider: mockJobProvider{name: "mock/High"}, priority: 10})
+	providers.RegisterJobKind(&limitedMockJobProvider{mockJobProvider: mockJobProvider{name: "mock/Limited"}, maxConcurrency: 2})
+
+	tx, err := container.DB.Beginx()
+	if err != nil {
+		t.Fatalf("Could not begin transaction: %v", err)
+	}
+	for i, name := range []string{"mock/Low", "mock/Limited", "mock/Limited", "mock/Limited", "mock/High"} {
+		if _, err := providers.Enqueue(tx, name, nil, jobs.EnqueueOptions{At: time.Now().Add(time.Duration(i-10) * time.Minute)}); err != nil {
+			t.Fatalf("Error enqueueing %s: %v", name, err)
+		}
+	}
+	// One limited job is already running in another instance
+	if _, err := tx.Exec("INSERT INTO jobs (name, state, worker_id) VALUES ('mock/Limited', 'running', 'other-instance')"); err != nil {
+		t.Fatalf("Error inserting running job: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Could not commit jobs: %v", err)
+	}
+
+	jobChannel := make(chan jobs.DBJob, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	errChannel := make(chan error, 1)
+	go func() {
+		errChannel <- providers.FetchAvailableJobs(&mockJobRunContext{db: container.DB}, testutil.MakeTestLogger().Logger, ctx, jobChannel)
+	}()
+
+	var claimed []string
+	timeout := time.After(2 * time.Second)
+collect:
+	for {
+		select {
+		case job := <-jobChannel:
+			claimed = append(claimed, job.Name)
+		case <-timeout:
+			break collect
+		}
+	}
+
+	assert.Equal(t, []string{"mock/High", "mock/Low", "mock/Limited"}, claimed)
+
+	cancel()
+	select {
+	case err := <-errChannel:
+		assert.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Error("Timed out waiting for FetchAvailableJobs to cancel")
+	}
+}
+
+func TestUniqueKey(t *testing.T) {
+	t.Parallel()
+
+	key, err := jobs.UniqueKey("update/Clan", `{"tag": "#ABC", "depth": 1}`)
+	assert.NoError(t, err)
+	same, err := jobs.UniqueKey("update/Clan", map[string]any{"depth": 1, "tag": "#ABC"})
+	assert.NoError(t, err)
+	assert.Equal(t, key, same)
+
+	otherData, err := jobs.UniqueKey("update/Clan", `{"tag": "#DEF", "depth": 1}`)
+	assert.NoError(t, err)
+	assert.NotEqual(t, key, otherData)
+	otherName, err := jobs.UniqueKey("update/Player", `{"tag": "#ABC", "depth": 1}`)
+	assert.NoError(t, err)
+	assert.NotEqual(t, key, otherName)
+
+	_, err = jobs.UniqueKey("update/Clan", "not json")
+	assert.Error(t, err)
+}
+
+func TestEnqueueUniqueJobs(t *testing.T) {
+	t.Parallel()
+
+	container, err := testutil.CreatePostgresContainer()
+	if err != nil {
+		t.Fatalf("Could not set up test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			t.Errorf("Error shuting down test container: %v", err)
+		}
+	})
+
+	now := time.Now().Truncate(time.Microsecond)
+	enqueue := func(data string, opts jobs.EnqueueOptions) int64 {
+		tx, err := container.DB.Beginx()
+		if err != nil {
+			t.Fatalf("Could not begin transaction: %v", err)
+		}
+		defer tx.Rollback()
+		id, err := jobs.Enqueue(tx, "update/Clan", data, opts)
+		if err != nil {
+			t.Fatalf("Error enqueueing job: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Could not commit job: %v", err)
+		}
+		return id
+	}
+	availableAt := func(id int64) time.Time {
+		var at time.Time
+		if err := container.DB.Get(&at, "SELECT available_at FROM jobs WHERE id = $1", id); err != nil {
+			t.Fatalf("Could not read job %d: %v", id, err)
+		}
+		return at
+	}
+
+	first := enqueue(`{"tag": "#ABC"}`, jobs.EnqueueOptions{At: now.Add(time.Hour), Unique: true})
+
+	// Keeping the earliest only moves the job forward
+	assert.Equal(t, first, enqueue(`{ "tag":"#ABC" }`, jobs.EnqueueOptions{At: now.Add(2 * time.Hour), Unique: true}))
+	assert.True(t, now.Add(time.Hour).Equal(availableAt(first)))
+	assert.Equal(t, first, enqueue(`{"tag": "#ABC"}`, jobs.EnqueueOptions{At: now.Add(30 * time.Minute), Unique: true}))
+	assert.True(t, now.Add(30*time.Minute).Equal(availableAt(first)))
+
+	assert.Equal(t, first, enqueue(`{"tag": "#ABC"}`, jobs.EnqueueOptions{At: now.Add(3 * time.Hour), Unique: true, OnConflict: jobs.ConflictReplace}))
+	assert.True(t, now.Add(3*time.Hour).Equal(availableAt(first)))
+
+	assert.Equal(t, first, enqueue(`{"tag": "#ABC"}`, jobs.EnqueueOptions{At: now, Unique: true, OnConflict: jobs.ConflictSkip}))
+	assert.True(t, now.Add(3*time.Hour).Equal(availableAt(first)))
+
+	other := enqueue(`{"tag": "#DEF"}`, jobs.EnqueueOptions{Unique: true})
+	assert.NotEqual(t, first, other)
+
+	// Failed jobs don't count, but only one of them can be requeued
+	if _, err := container.DB.Exec("UPDATE jobs SET state = 'failed' WHERE id = $1", first); err != nil {
+		t.Fatalf("Could not fail job: %v", err)
+	}
+	second := enqueue(`{"tag": "#ABC"}`, jobs.EnqueueOptions{Unique: true})
+	assert.NotEqual(t, first, second)
+	requeued, err := jobs.RequeueFailedJobs(container.DB, "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), requeued)
+
+	var count int
+	if err := container.DB.Get(&count, "SELECT COUNT(*) FROM jobs WHERE state = 'pending'"); err != nil {
+		t.Fatalf("Could not count jobs: %v", err)
+	}
+	assert.Equal(t, 2, count)
+}
+
+type timeoutMockJobProvider struct {
+	mockJobProvider
+	timeout time.Duration
+}
+
+func (p *timeoutMockJobProvider) Timeout() time.Duration { return p.timeout }
+
+func TestJobTimeoutsAndShutdown(t *testing.T) {
+	t.Parallel()
+
+	var prepareForTestCase = func(t *testing.T, conf jobs.JobLoopConfiguration, provider jobs.JobProvider) (container *testutil.TestDatabase, stop func() time.Duration) {
+		container, err := testutil.CreatePostgresContainer()
+		if err != nil {
+			t.Fatalf("Could not set up test database: %v", err)
+		}
+		t.Cleanup(func() {
+			if err := container.Shutdown(); err != nil {
+				t.Errorf("Error shuting down test container: %v", err)
+			}
+		})
+
+		if _, err := container.DB.Exec("INSERT INTO jobs (name) VALUES ($1)", provider.JobName()); err != nil {
+			t.Fatalf("Error inserting job: %v", err)
+		}
+
+		providers := jobs.NewJobQueue()
+		providers.Configure(conf)
+		providers.RegisterJobKind(provider)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		loopDone := make(chan struct{})
+		go func() {
+			defer close(loopDone)
+			providers.RunJobLoop(&mockJobRunContext{db: container.DB}, testutil.MakeTestLogger().Logger, ctx)
+		}()
+
+		stop = func() time.Duration {
+			stopping := time.Now()
+			cancel()
+			select {
+			case <-loopDone:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Timed out waiting for RunJobLoop to stop")
+			}
+			return time.Since(stopping)
+		}
+		return
+	}
+	readJob := func(t *testing.T, container *testutil.TestDatabase) jobs.DBJob {
+		var job jobs.DBJob
+		if err := container.DB.Get(&job, "SELECT * FROM jobs WHERE id = 1"); err != nil {
+			t.Fatalf("Could not read job: %v", err)
+		}
+		return job
+	}
+	blockingRun := func(started chan<- struct{}, release <-chan struct{}) func(context.Context, string) (*jobs.JobFinishInformation, error) {
+		return func(ctx context.Context, _ string) (*jobs.JobFinishInformation, error) {
+			started <- struct{}{}
+			select {
+			case <-release:
+				return &jobs.JobFinishInformation{Successfull: true}, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	t.Run("Job that times out counts as a failed attempt", func(t *testing.T) {
+		t.Parallel()
+		started := make(chan struct{}, 1)
+		conf := jobs.DefaultJobLoopConfiguration()
+		conf.Workers = 1
+		container, stop := prepareForTestCase(t, conf, &timeoutMockJobProvider{
+			mockJobProvider: mockJobProvider{name: "mock/Hung", retry: jobs.RetryPolicy{MaxAttempts: 1}, run: blockingRun(started, nil)},
+			timeout:         200 * time.Millisecond,
+		})
+		t.Cleanup(func() { stop() })
+
+		assert.Eventually(t, func() bool {
+			return readJob(t, container).State == jobs.JobStateFailed
+		}, 5*time.Second, 100*time.Millisecond, "Job did not time out")
+		job := readJob(t, container)
+		assert.Equal(t, 1, job.Attempts)
+		if assert.NotNil(t, job.LastError) {
+			assert.Contains(t, *job.LastError, "timed out after 200ms")
+		}
+	})
+
+	t.Run("Running job can finish during the grace period", func(t *testing.T) {
+		t.Parallel()
+		started, release := make(chan struct{}, 1), make(chan struct{})
+		conf := jobs.DefaultJobLoopConfiguration()
+		conf.Workers = 1
+		conf.ShutdownGracePeriod = 5 * time.Second
+		container, stop := prepareForTestCase(t, conf, &mockJobProvider{name: "mock/Slow", run: blockingRun(started, release)})
+
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the job to start")
+		}
+		time.AfterFunc(300*time.Millisecond, func() { close(release) })
+		assert.Less(t, stop(), 3*time.Second)
+
+		var count int
+		if err := container.DB.Get(&count, "SELECT COUNT(*) FROM jobs"); err != nil {
+			t.Fatalf("Could not count jobs: %v", err)
+		}
+		assert.Equal(t, 0, count, "Job finished during the grace period was not removed")
+	})
+
+	t.Run("Running job goes back to pending after the grace period", func(t *testing.T) {
+		t.Parallel()
+		started := make(chan struct{}, 1)
+		conf := jobs.DefaultJobLoopConfiguration()
+		conf.Workers = 1
+		conf.ShutdownGracePeriod = 300 * time.Millisecond
+		container, stop := prepareForTestCase(t, conf, &mockJobProvider{name: "mock/Slow", run: blockingRun(started, nil)})
+
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the job to start")
+		}
+		elapsed := stop()
+		assert.GreaterOrEqual(t, elapsed, 300*time.Millisecond)
+
+		job := readJob(t, container)
+		assert.Equal(t, jobs.JobStatePending, job.State)
+		assert.Nil(t, job.WorkerId)
+	})
+}
+
+type followUpMockJob struct {
+	data string
+}
+
+// Stores a capital league and enqueues a follow-up job in the same transaction,
+// failing before committing when asked to.
+func (j *followUpMockJob) Run(jctx jobs.JobRunContext, ctx context.Context) (*jobs.JobFinishInformation, error) {
+	tx, err := jctx.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("INSERT INTO capital_leagues (id, name) VALUES (1, 'League')"); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Enqueue("mock/FollowUp", nil, jobs.EnqueueOptions{At: time.Now().Add(time.Hour)}); err != nil {
+		return nil, err
+	}
+	if j.data == `{"fail": true}` {
+		return nil, fmt.Errorf("failed before committing")
+	}
+	return &jobs.JobFinishInformation{Successfull: true}, tx.Commit()
+}
+
+func (j *followUpMockJob) Serialize(*sqlx.DB) error { return nil }
+
+type followUpMockJobProvider struct {
+	mockJobProvider
+}
+
+func (p *followUpMockJobProvider) Deserialize(data string) (jobs.Job, error) {
+	return &followUpMockJob{data: data}, nil
+}
+
+func TestEnqueueFromJobRun(t *testing.T) {
+	t.Parallel()
+
+	for _, fail := range []bool{false, true} {
+		t.Run(fmt.Sprintf("Job fails before committing: %v", fail), func(t *testing.T) {
+			t.Parallel()
+
+			container, err := testutil.CreatePostgresContainer()
+			if err != nil {
+				t.Fatalf("Could not set up test database: %v", err)
+			}
+			t.Cleanup(func() {
+				if err := container.Shutdown(); err != nil {
+					t.Errorf("Error shuting down test container: %v", err)
+				}
+			})
+
+			if _, err := container.DB.Exec("INSERT INTO jobs (name, data) VALUES ('mock/Refresh', $1)", fmt.Sprintf(`{"fail": %v}`, fail)); err != nil {
+				t.Fatalf("Error inserting job: %v", err)
+			}
+
+			providers := jobs.NewJobQueue()
+			providers.RegisterJobKind(&followUpMockJobProvider{mockJobProvider{name: "mock/Refresh", retry: jobs.RetryPolicy{MaxAttempts: 1}}})
+			providers.RegisterJobKind(&mockJobProvider{name: "mock/FollowUp"})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			t.Cleanup(cancel)
+			loopDone := make(chan struct{})
+			go func() {
+				defer close(loopDone)
+				providers.RunJobLoop(&mockJobRunContext{db: container.DB, queue: providers}, testutil.MakeTestLogger().Logger, ctx)
+			}()
+
+			assert.Eventually(t, func() bool {
+				var count int
+				container.DB.Get(&count, "SELECT COUNT(*) FROM jobs WHERE name = 'mock/Refresh' AND state IN ('pending', 'queued', 'running')")
+				return count == 0
+			}, 5*time.Second, 100*time.Millisecond, "Job did not run")
+			cancel()
+			<-loopDone
+
+			var leagues int
+			if err := container.DB.Get(&leagues, "SELECT COUNT(*) FROM capital_leagues"); err != nil {
+				t.Fatalf("Could not count leagues: %v", err)
+			}
+			followUps, err := jobs.ChildrenOf(container.DB, 1)
+			assert.NoError(t, err)
+
+			if fail {
+				assert.Equal(t, 0, leagues)
+				assert.Empty(t, followUps)
+				return
+			}
+			assert.Equal(t, 1, leagues)
+			if assert.Len(t, followUps, 1) {
+				assert.Equal(t, "mock/FollowUp", followUps[0].Name)
+				assert.Equal(t, jobs.JobStatePending, followUps[0].State)
+			}
+		})
+	}
+}
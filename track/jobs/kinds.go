@@ -0,0 +1,202 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// JobKind is the state shared by all the instances about a kind of job.
+type JobKind struct {
+	Name                string     `db:"name"`
+	Paused              bool       `db:"paused"`
+	PausedAt            *time.Time `db:"paused_at"`
+	PauseReason         *string    `db:"pause_reason"`
+	ConsecutiveFailures int        `db:"consecutive_failures"`
+}
+
+// ListJobKinds returns the stored state of every kind that was ever paused or
+// failed, sorted by name.
+func ListJobKinds(db *sqlx.DB) ([]JobKind, error) {
+	kinds := []JobKind{}
+	err := db.Select(&kinds, "SELECT * FROM job_kinds ORDER BY name")
+	return kinds, err
+}
+
+// PauseKinds stops every instance from claiming jobs of the registered kinds
+// whose name matches the pattern, as in path.Match, so "update/*" pauses all
+// the update jobs. Jobs that are already running are not affected. Returns the
+// names of the paused kinds.
+func (q *RegisteredJobs) PauseKinds(db *sqlx.DB, pattern string, reason string) ([]string, error) {
+	names, err := q.matchKinds(pattern)
+	if err != nil || len(names) == 0 {
+		return names, err
+	}
+
+	for _, name := range names {
+		if err := q.pauseKind(db, name, reason); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+func (q *RegisteredJobs) pauseKind(db *sqlx.DB, name string, reason string) error {
+	_, err := db.Exec(`
+	INSERT INTO job_kinds (name, paused, paused_at, pause_reason)
+	VALUES ($1, TRUE, CURRENT_TIMESTAMP, $2)
+	ON CONFLICT (name)
+	DO UPDATE SET paused = TRUE, paused_at = CURRENT_TIMESTAMP, pause_reason = EXCLUDED.pause_reason
+	`, name, reason)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.paused[name] = true
+	return nil
+}
+
+// ResumeKinds undoes PauseKinds, also resetting the failure count of the kinds.
+func (q *RegisteredJobs) ResumeKinds(db *sqlx.DB, pattern string) ([]string, error) {
+	names, err := q.matchKinds(pattern)
+	if err != nil || len(names) == 0 {
+		return names, err
+	}
+
+	for _, name := range names {
+		_, err := db.Exec(`
+		WITH resumed AS (
+			UPDATE job_kinds
+			SET paused = FALSE, paused_at = NULL, pause_reason = NULL, consecutive_failures = 0
+			WHERE name = $1
+			RETURNING name
+		)
+		SELECT pg_notify($2, name) FROM resumed
+		`, name, jobsAvailableChannel)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, name := range names {
+		delete(q.paused, name)
+	}
+	return names, nil
+}
+
+func (q *RegisteredJobs) matchKinds(pattern string) ([]string, error) {
+	var names []string
+	for _, name := range q.JobNames() {
+		matches, err := path.Match(pattern, name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid job kind pattern %q: %w", pattern, err)
+		}
+		if matches {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// IsPaused reports whether the kind was paused the last time this instance
+// looked for jobs or since then.
+func (q *RegisteredJobs) IsPaused(name string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.paused[name]
+}
+
+// Hold stops this instance from claiming any job until Unhold is called, for
+// conditions that affect every kind, like the API being in maintenance. Unlike
+// paused kinds, holds are not shared with other instances.
+func (q *RegisteredJobs) Hold() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.held = true
+}
+
+// Unhold lets this instance claim jobs again and wakes up its job loop, so
+// jobs that became due while held do not wait for the next poll.
+func (q *RegisteredJobs) Unhold(db *sqlx.DB) error {
+	q.mu.Lock()
+	q.held = false
+	q.mu.Unlock()
+
+	_, err := db.Exec("SELECT pg_notify($1, '')", jobsAvailableChannel)
+	return err
+}
+
+func (q *RegisteredJobs) IsHeld() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.held
+}
+
+// loadPausedKinds refreshes which kinds are paused and returns their names.
+func (q *RegisteredJobs) loadPausedKinds(ctx context.Context, tx *sqlx.Tx) ([]string, error) {
+	names := []string{}
+	if err := tx.SelectContext(ctx, &names, "SELECT name FROM job_kinds WHERE paused"); err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	clear(q.paused)
+	for _, name := range names {
+		q.paused[name] = true
+	}
+	return names, nil
+}
+
+// recordKindOutcome keeps count of the consecutive runs of a kind that did not
+// succeed, pausing the kind once the count reaches the configured limit unless
+// it is paused already.
+func (q *RegisteredJobs) recordKindOutcome(db *sqlx.DB, logger *slog.Logger, name string, outcome JobRunOutcome) {
+	switch outcome {
+	case JobRunSucceeded:
+		_, err := db.Exec("UPDATE job_kinds SET consecutive_failures = 0 WHERE name = $1 AND consecutive_failures > 0", name)
+		if err != nil {
+			logger.Error("Error resetting job kind failures", "err", err)
+		}
+		return
+	case JobRunRetrying, JobRunFailed, JobRunUnsuccessful:
+	default:
+		return
+	}
+
+	var kind struct {
+		Failures int  `db:"consecutive_failures"`
+		Paused   bool `db:"paused"`
+	}
+	err := db.Get(&kind, `
+	INSERT INTO job_kinds (name, consecutive_failures)
+	VALUES ($1, 1)
+	ON CONFLICT (name)
+	DO UPDATE SET consecutive_failures = job_kinds.consecutive_failures + 1
+	RETURNING consecutive_failures, paused
+	`, name)
+	if err != nil {
+		logger.Error("Error counting job kind failures", "err", err)
+		return
+	}
+
+	failures := kind.Failures
+	limit := q.config.PauseAfterFailures
+	if limit <= 0 || failures < limit || kind.Paused {
+		return
+	}
+	reason := fmt.Sprintf("paused after %d consecutive failures", failures)
+	if err := q.pauseKind(db, name, reason); err != nil {
+		logger.Error("Error pausing failing job kind", "err", err)
+		return
+	}
+	logger.Error("Paused job kind after too many consecutive failures", "failures", failures)
+}
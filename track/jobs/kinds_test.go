@@ -0,0 +1,166 @@
+package jobs_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	testutil "github.com/MrNemo64/coc-tracker/test_util"
+	"github.com/MrNemo64/coc-tracker/track/jobs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPauseJobKinds(t *testing.T) {
+	t.Parallel()
+
+	container, err := testutil.CreatePostgresContainer()
+	if err != nil {
+		t.Fatalf("Could not set up test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			t.Errorf("Error shuting down test container: %v", err)
+		}
+	})
+
+	for _, name := range []string{"mock/A", "mock/B", "other/C"} {
+		if _, err := container.DB.Exec("INSERT INTO jobs (name) VALUES ($1)", name); err != nil {
+			t.Fatalf("Error inserting job %s: %v", name, err)
+		}
+	}
+
+	providers := jobs.NewJobQueue()
+	for _, name := range []string{"mock/A", "mock/B", "other/C"} {
+		providers.RegisterJobKind(&mockJobProvider{name: name})
+	}
+
+	paused, err := providers.PauseKinds(container.DB, "mock/*", "testing")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mock/A", "mock/B"}, paused)
+	_, err = providers.PauseKinds(container.DB, "[", "")
+	assert.Error(t, err)
+
+	// Another instance sees the kinds as paused once it looks for jobs
+	other := jobs.NewJobQueue()
+	for _, name := range []string{"mock/A", "mock/B", "other/C"} {
+		other.RegisterJobKind(&mockJobProvider{name: name})
+	}
+
+	jobChannel := make(chan jobs.DBJob, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go other.FetchAvailableJobs(&mockJobRunContext{db: container.DB}, testutil.MakeTestLogger().Logger, ctx, jobChannel)
+
+	select {
+	case job := <-jobChannel:
+		assert.Equal(t, "other/C", job.Name)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the unpaused job")
+	}
+	select {
+	case job := <-jobChannel:
+		t.Fatalf("Claimed job %s of a paused kind", job.Name)
+	case <-time.After(time.Second):
+	}
+	assert.True(t, other.IsPaused("mock/A"))
+
+	kinds, err := jobs.ListJobKinds(container.DB)
+	assert.NoError(t, err)
+	if assert.Len(t, kinds, 2) {
+		assert.True(t, kinds[0].Paused)
+		if assert.NotNil(t, kinds[0].PauseReason) {
+			assert.Equal(t, "testing", *kinds[0].PauseReason)
+		}
+	}
+
+	resumed, err := providers.ResumeKinds(container.DB, "mock/A")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mock/A"}, resumed)
+	select {
+	case job := <-jobChannel:
+		assert.Equal(t, "mock/A", job.Name)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the resumed job")
+	}
+	assert.False(t, other.IsPaused("mock/A"))
+	assert.True(t, other.IsPaused("mock/B"))
+}
+
+func TestPauseFailingJobKind(t *testing.T) {
+	t.Parallel()
+
+	container, err := testutil.CreatePostgresContainer()
+	if err != nil {
+		t.Fatalf("Could not set up test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			t.Errorf("Error shuting down test container: %v", err)
+		}
+	})
+
+	if _, err := container.DB.Exec("INSERT INTO jobs (name) VALUES ('mock/Fail')"); err != nil {
+		t.Fatalf("Error inserting job: %v", err)
+	}
+
+	ran := make(chan struct{}, 5)
+	providers := jobs.NewJobQueue()
+	conf := jobs.DefaultJobLoopConfiguration()
+	conf.PauseAfterFailures = 2
+	providers.Configure(conf)
+	providers.RegisterJobKind(&mockJobProvider{
+		name: "mock/Fail",
+		run: func(context.Context, string) (*jobs.JobFinishInformation, error) {
+			ran <- struct{}{}
+			return nil, fmt.Errorf("boom")
+		},
+		retry: jobs.RetryPolicy{
+			MaxAttempts: 5,
+			BaseDelay:   10 * time.Millisecond,
+			MaxDelay:    10 * time.Millisecond,
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	loopDone := make(chan struct{})
+	go func() {
+		defer close(loopDone)
+		providers.RunJobLoop(&mockJobRunContext{db: container.DB}, testutil.MakeTestLogger().Logger, ctx)
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-ran:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for attempt %d", i+1)
+		}
+	}
+	select {
+	case <-ran:
+		t.Fatal("Job ran after its kind was paused")
+	case <-time.After(time.Second):
+	}
+
+	cancel()
+	select {
+	case <-loopDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for RunJobLoop to stop")
+	}
+
+	assert.True(t, providers.IsPaused("mock/Fail"))
+	kinds, err := jobs.ListJobKinds(container.DB)
+	assert.NoError(t, err)
+	if assert.Len(t, kinds, 1) {
+		assert.True(t, kinds[0].Paused)
+		assert.Equal(t, 2, kinds[0].ConsecutiveFailures)
+	}
+
+	job, err := jobs.GetJob(container.DB, 1)
+	if assert.NoError(t, err) && assert.NotNil(t, job) {
+		assert.Equal(t, jobs.JobStatePending, job.State)
+		assert.Equal(t, 2, job.Attempts)
+	}
+}
@@ -0,0 +1,110 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/MrNemo64/coc-tracker/track/cache"
+	"github.com/jmoiron/sqlx"
+)
+
+// ReapExpiredLeases returns to pending the queued and running jobs whose owner
+// stopped renewing their lease, most likely because the instance crashed.
+func ReapExpiredLeases(db *sqlx.DB) ([]int64, error) {
+	var ids []int64
+	err := db.Select(&ids, `
+	UPDATE jobs
+	SET state = 'pending', worker_id = NULL, locked_until = NULL
+	WHERE
+		state IN ('queued', 'running')
+		AND (locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP)
+	RETURNING id
+	`)
+	return ids, err
+}
+
+func (q *RegisteredJobs) reapExpiredLeases(db *sqlx.DB, logger *slog.Logger) {
+	ids, err := ReapExpiredLeases(db)
+	if err != nil {
+		logger.Error("Error reaping expired job leases", "err", err)
+		return
+	}
+	if len(ids) > 0 {
+		logger.Warn("Returned jobs with expired leases to pending", "jobs", ids)
+	}
+}
+
+func (q *RegisteredJobs) pruneResponseCache(db *sqlx.DB, logger *slog.Logger) {
+	pruned, err := cache.PruneExpired(db, time.Now())
+	if err != nil {
+		logger.Error("Error pruning expired cached responses", "err", err)
+		return
+	}
+	if pruned > 0 {
+		logger.Debug("Pruned expired cached responses", "responses", pruned)
+	}
+}
+
+// runMaintenance periodically reaps expired leases, makes sure recurring jobs
+// that ran out of attempts are scheduled again, prunes old job runs and
+// deletes expired cached responses.
+func (q *RegisteredJobs) runMaintenance(db *sqlx.DB, logger *slog.Logger, ctx context.Context) {
+	ticker := time.NewTicker(q.config.ReapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			q.reapExpiredLeases(db, logger)
+			q.ensureSchedules(db, logger)
+			q.pruneJobRuns(db, logger)
+			q.pruneResponseCache(db, logger)
+		}
+	}
+}
+
+func (q *RegisteredJobs) renewLease(db *sqlx.DB, id int64) (renewed bool, cancelRequested bool, err error) {
+	var requested []bool
+	err = db.Select(&requested, `
+	UPDATE jobs
+	SET locked_until = CURRENT_TIMESTAMP + make_interval(secs => $3)
+	WHERE id = $1 AND worker_id = $2 AND state = 'running'
+	RETURNING cancel_requested
+	`, id, q.config.InstanceId, q.config.Lease.Seconds())
+	if err != nil || len(requested) == 0 {
+		return false, false, err
+	}
+	return true, requested[0], nil
+}
+
+// heartbeat keeps renewing the lease of a running job until stop is closed,
+// calling cancel with errLeaseLost if the lease can no longer be renewed or
+// with ErrJobCancelled if the job was cancelled from another instance.
+func (q *RegisteredJobs) heartbeat(db *sqlx.DB, logger *slog.Logger, id int64, stop <-chan struct{}, cancel func(cause error)) {
+	ticker := time.NewTicker(q.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			renewed, cancelRequested, err := q.renewLease(db, id)
+			if err != nil {
+				logger.Error("Error renewing job lease", "err", err)
+				continue
+			}
+			if !renewed {
+				logger.Error("Lost the lease of the running job, cancelling it")
+				cancel(errLeaseLost)
+				return
+			}
+			if cancelRequested {
+				logger.Warn("Running job was cancelled")
+				cancel(ErrJobCancelled)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package jobs_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	testutil "github.com/MrNemo64/coc-tracker/test_util"
+	"github.com/MrNemo64/coc-tracker/track/jobs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReapExpiredLeases(t *testing.T) {
+	t.Parallel()
+
+	container, err := testutil.CreatePostgresContainer()
+	if err != nil {
+		t.Fatalf("Could not set up test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			t.Errorf("Error shuting down test container: %v", err)
+		}
+	})
+
+	now := time.Now()
+	expired := now.Add(-time.Minute)
+	valid := now.Add(time.Hour)
+	deadWorker := "dead-instance"
+	liveWorker := "live-instance"
+	mockJobs := []jobs.DBJob{
+		{Id: 1, Name: "Job 1", Data: "{}", State: jobs.JobStateRunning, CreatedAt: now, AvailableAt: now, Attempts: 1, LockedUntil: &expired, WorkerId: &deadWorker},
+		{Id: 2, Name: "Job 2", Data: "{}", State: jobs.JobStateQueued, CreatedAt: now, AvailableAt: now, LockedUntil: &valid, WorkerId: &liveWorker},
+		{Id: 3, Name: "Job 3", Data: "{}", State: jobs.JobStateQueued, CreatedAt: now, AvailableAt: now},
+		{Id: 4, Name: "Job 4", Data: "{}", State: jobs.JobStatePending, CreatedAt: now, AvailableAt: now},
+	}
+	for _, job := range mockJobs {
+		if _, err := container.DB.NamedExec(
+			`INSERT INTO jobs(id, name, data, state, created_at, available_at, attempts, locked_until, worker_id)
+			VALUES (:id, :name, :data, :state, :created_at, :available_at, :attempts, :locked_until, :worker_id)`,
+			job); err != nil {
+			t.Fatalf("Error inserting job %v: %v", job, err)
+		}
+	}
+
+	reaped, err := jobs.ReapExpiredLeases(container.DB)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []int64{1, 3}, reaped)
+
+	mockJobs[0].State = jobs.JobStatePending
+	mockJobs[0].LockedUntil = nil
+	mockJobs[0].WorkerId = nil
+	mockJobs[2].State = jobs.JobStatePending
+	container.AssertJobsTableEquals(t, mockJobs)
+}
+
+func TestJobLeaseHeartbeat(t *testing.T) {
+	t.Parallel()
+
+	var prepareForTestCase = func(t *testing.T) (container *testutil.TestDatabase, providers *jobs.RegisteredJobs, started chan context.Context, release chan struct{}, stop func()) {
+		container, err := testutil.CreatePostgresContainer()
+		if err != nil {
+			t.Fatalf("Could not set up test database: %v", err)
+		}
+
+		if _, err := container.DB.Exec("INSERT INTO jobs (name) VALUES ('mock/Slow')"); err != nil {
+			t.Fatalf("Error inserting job: %v", err)
+		}
+
+		started = make(chan context.Context, 1)
+		release = make(chan struct{})
+		conf := jobs.DefaultJobLoopConfiguration()
+		conf.Workers = 1
+		conf.Lease = time.Second
+		conf.HeartbeatInterval = 200 * time.Millisecond
+		providers = jobs.NewJobQueue()
+		providers.Configure(conf)
+		providers.RegisterJobKind(&mockJobProvider{
+			name: "mock/Slow",
+			run: func(ctx context.Context, _ string) (*jobs.JobFinishInformation, error) {
+				started <- ctx
+				select {
+				case <-release:
+					return &jobs.JobFinishInformation{Successfull: true}, nil
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			},
+		})
+
+		logger := testutil.MakeTestLogger()
+		ctx, cancel := context.WithCancel(context.Background())
+		loopDone := make(chan struct{})
+		go func() {
+			defer close(loopDone)
+			providers.RunJobLoop(&mockJobRunContext{db: container.DB}, logger.Logger, ctx)
+		}()
+
+		stop = func() {
+			cancel()
+			select {
+			case <-loopDone:
+			case <-time.After(5 * time.Second):
+				t.Error("Timed out waiting for RunJobLoop to stop")
+			}
+			if err := container.Shutdown(); err != nil {
+				t.Errorf("Error shuting down test container: %v", err)
+			}
+		}
+		return
+	}
+
+	t.Run("Running job keeps its lease past the lease duration", func(t *testing.T) {
+		t.Parallel()
+		container, _, started, release, stop := prepareForTestCase(t)
+		t.Cleanup(stop)
+
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the job to start")
+		}
+
+		time.Sleep(2500 * time.Millisecond)
+		reaped, err := jobs.ReapExpiredLeases(container.DB)
+		assert.NoError(t, err)
+		assert.Empty(t, reaped)
+
+		var job jobs.DBJob
+		if err := container.DB.Get(&job, "SELECT * FROM jobs WHERE id = 1"); err != nil {
+			t.Fatalf("Could not read job: %v", err)
+		}
+		assert.Equal(t, jobs.JobStateRunning, job.State)
+		if assert.NotNil(t, job.LockedUntil) {
+			assert.True(t, job.LockedUntil.After(time.Now()), "Lease expired while running")
+		}
+
+		close(release)
+		assert.Eventually(t, func() bool {
+			var count int
+			container.DB.Get(&count, "SELECT COUNT(*) FROM jobs")
+			return count == 0
+		}, 3*time.Second, 100*time.Millisecond, "Finished job was not removed")
+	})
+
+	t.Run("Job is cancelled when another instance takes its lease", func(t *testing.T) {
+		t.Parallel()
+		container, _, started, _, stop := prepareForTestCase(t)
+		t.Cleanup(stop)
+
+		var runCtx context.Context
+		select {
+		case runCtx = <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the job to start")
+		}
+
+		if _, err := container.DB.Exec("UPDATE jobs SET worker_id = 'other-instance' WHERE id = 1"); err != nil {
+			t.Fatalf("Could not steal the lease: %v", err)
+		}
+
+		select {
+		case <-runCtx.Done():
+		case <-time.After(3 * time.Second):
+			t.Fatal("Job was not cancelled after losing its lease")
+		}
+
+		// The run is recorded once the worker is done with the job
+		assert.Eventually(t, func() bool {
+			var runs int
+			container.DB.Get(&runs, "SELECT COUNT(*) FROM job_runs WHERE outcome = 'lease_lost'")
+			return runs == 1
+		}, 5*time.Second, 20*time.Millisecond, "The run was not recorded as lease lost")
+		var job jobs.DBJob
+		if err := container.DB.Get(&job, "SELECT * FROM jobs WHERE id = 1"); err != nil {
+			t.Fatalf("Could not read job: %v", err)
+		}
+		assert.Equal(t, jobs.JobStateRunning, job.State)
+		if assert.NotNil(t, job.WorkerId) {
+			assert.Equal(t, "other-instance", *job.WorkerId)
+		}
+	})
+}
+
+func TestCancelRunningJob(t *testing.T) {
+	t.Parallel()
+
+	container, err := testutil.CreatePostgresContainer()
+	if err != nil {
+		t.Fatalf("Could not set up test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			t.Errorf("Error shuting down test container: %v", err)
+		}
+	})
+
+	for _, query := range []string{
+		"INSERT INTO jobs (name) VALUES ('mock/Slow')",
+		"INSERT INTO jobs (name, state) VALUES ('mock/Slow', 'blocked')",
+		"INSERT INTO job_dependencies (job_id, depends_on) VALUES (2, 1)",
+	} {
+		if _, err := container.DB.Exec(query); err != nil {
+			t.Fatalf("Error preparing jobs: %v", err)
+		}
+	}
+
+	started := make(chan struct{}, 1)
+	providers := jobs.NewJobQueue()
+	providers.RegisterJobKind(&mockJobProvider{
+		name: "mock/Slow",
+		run: func(ctx context.Context, _ string) (*jobs.JobFinishInformation, error) {
+			started <- struct{}{}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	loopDone := make(chan struct{})
+	go func() {
+		defer close(loopDone)
+		providers.RunJobLoop(&mockJobRunContext{db: container.DB}, testutil.MakeTestLogger().Logger, ctx)
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the job to start")
+	}
+	cancelled, err := providers.CancelJob(container.DB, 1)
+	assert.NoError(t, err)
+	assert.True(t, cancelled)
+
+	assert.Eventually(t, func() bool {
+		job, err := jobs.GetJob(container.DB, 1)
+		return err == nil && job != nil && job.State == jobs.JobStateFailed
+	}, 3*time.Second, 100*time.Millisecond, "Cancelled job was not failed")
+	cancel()
+	<-loopDone
+
+	for _, id := range []int64{1, 2} {
+		job, err := jobs.GetJob(container.DB, id)
+		if assert.NoError(t, err) && assert.NotNil(t, job) {
+			assert.Equal(t, jobs.JobStateFailed, job.State)
+			assert.False(t, job.CancelRequested)
+		}
+	}
+	runs, err := jobs.ListJobRuns(container.DB, jobs.JobRunFilter{JobId: 1})
+	assert.NoError(t, err)
+	if assert.Len(t, runs, 1) {
+		assert.Equal(t, jobs.JobRunCancelled, runs[0].Outcome)
+	}
+
+	cancelled, err = providers.CancelJob(container.DB, 1)
+	assert.NoError(t, err)
+	assert.False(t, cancelled)
+}
@@ -0,0 +1,78 @@
+package jobs
+
+import (
+	"context"
+	"time"
+)
+
+// PriorityProvider can be implemented by a JobProvider to give its jobs a
+// priority other than 0. Jobs with higher priority are claimed first.
+type PriorityProvider interface {
+	DefaultPriority() int
+}
+
+// ConcurrencyLimitedProvider can be implemented by a JobProvider to limit how
+// many jobs of its kind can be queued or running at once across all instances.
+// A limit of 0 or less means no limit.
+type ConcurrencyLimitedProvider interface {
+	MaxConcurrency() int
+}
+
+// TimeoutProvider can be implemented by a JobProvider to bound how long a run
+// of its jobs can take, overriding JobLoopConfiguration.JobTimeout. The context
+// given to the job is cancelled once the timeout expires and the run counts as
+// a failed attempt. A timeout of 0 or less falls back to the default.
+type TimeoutProvider interface {
+	Timeout() time.Duration
+}
+
+func (q *RegisteredJobs) timeoutOf(provider JobProvider) time.Duration {
+	if p, ok := provider.(TimeoutProvider); ok && p.Timeout() > 0 {
+		return p.Timeout()
+	}
+	return q.config.JobTimeout
+}
+
+func defaultPriorityOf(provider JobProvider) int {
+	if p, ok := provider.(PriorityProvider); ok {
+		return p.DefaultPriority()
+	}
+	return 0
+}
+
+func maxConcurrencyOf(provider JobProvider) int {
+	if p, ok := provider.(ConcurrencyLimitedProvider); ok && p.MaxConcurrency() > 0 {
+		return p.MaxConcurrency()
+	}
+	return 0
+}
+
+func (q *RegisteredJobs) concurrencyLimits() (names []string, limits []int64) {
+	names, limits = []string{}, []int64{}
+	for name, slots := range q.kindSlots {
+		names = append(names, name)
+		limits = append(limits, int64(cap(slots)))
+	}
+	return
+}
+
+// acquireKindSlot blocks until this instance is running less jobs of the given
+// kind than its provider allows.
+func (q *RegisteredJobs) acquireKindSlot(ctx context.Context, name string) bool {
+	slots, ok := q.kindSlots[name]
+	if !ok {
+		return true
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (q *RegisteredJobs) releaseKindSlot(name string) {
+	if slots, ok := q.kindSlots[name]; ok {
+		<-slots
+	}
+}
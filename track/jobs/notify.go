@@ -0,0 +1,74 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const jobsAvailableChannel = "jobs_available"
+
+// listenForJobs subscribes to the notifications sent by the jobs table trigger.
+// A nil listener means the caller has to rely on polling.
+func (q *RegisteredJobs) listenForJobs(logger *slog.Logger) *pq.Listener {
+	if q.config.NotifyDSN == "" {
+		return nil
+	}
+
+	listener := pq.NewListener(q.config.NotifyDSN, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Warn("Job notification listener error", "event", event, "err", err)
+		}
+	})
+	if err := listener.Listen(jobsAvailableChannel); err != nil {
+		logger.Error("Error listening for job notifications, falling back to polling", "err", err)
+		listener.Close()
+		return nil
+	}
+	return listener
+}
+
+// waitForJobs sleeps until the next pending job becomes available, a
+// notification arrives or the poll interval runs out, whatever happens first.
+func (q *RegisteredJobs) waitForJobs(ctx context.Context, db *sqlx.DB, logger *slog.Logger, listener *pq.Listener) {
+	var notifications <-chan *pq.Notification
+	wait := q.config.PollInterval
+	if listener != nil {
+		notifications = listener.NotificationChannel()
+		wait = q.config.ListenPollInterval
+	}
+
+	var next sql.NullTime
+	err := db.GetContext(ctx, &next, `
+	SELECT MIN(available_at)
+	FROM jobs
+	WHERE state = 'pending' AND available_at > CURRENT_TIMESTAMP
+	`)
+	if err != nil && ctx.Err() == nil {
+		logger.Error("Error looking up the next available job", "err", err)
+	} else if next.Valid {
+		if untilNext := time.Until(next.Time); untilNext < wait {
+			wait = max(untilNext, 0)
+		}
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	case <-notifications:
+		// Several notifications are usually sent together, one fetch handles all of them
+		for {
+			select {
+			case <-notifications:
+			default:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package jobs
+
+import (
+	"database/sql"
+	"math/rand"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   30 * time.Second,
+	MaxDelay:    time.Hour,
+}
+
+// RetryPolicyProvider can be implemented by a JobProvider to override the
+// DefaultRetryPolicy for its jobs. Zero fields fall back to the default.
+type RetryPolicyProvider interface {
+	RetryPolicy() RetryPolicy
+}
+
+func retryPolicyOf(provider JobProvider) RetryPolicy {
+	policy := DefaultRetryPolicy
+	custom, ok := provider.(RetryPolicyProvider)
+	if !ok {
+		return policy
+	}
+	override := custom.RetryPolicy()
+	if override.MaxAttempts > 0 {
+		policy.MaxAttempts = override.MaxAttempts
+	}
+	if override.BaseDelay > 0 {
+		policy.BaseDelay = override.BaseDelay
+	}
+	if override.MaxDelay > 0 {
+		policy.MaxDelay = override.MaxDelay
+	}
+	return policy
+}
+
+// Backoff returns how long to wait before the next attempt after the given
+// number of failed attempts. The delay doubles with every attempt up to MaxDelay
+// and half of it is randomized so jobs failing together don't retry together.
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+type stopError struct {
+	err error
+}
+
+func (e *stopError) Error() string { return e.err.Error() }
+func (e *stopError) Unwrap() error { return e.err }
+
+// StopJob wraps the error returned by a job to fail it right away instead of
+// retrying it, for errors no retry can fix, like the tracked player no longer
+// existing. Stopped runs don't count towards pausing the kind.
+func StopJob(err error) error {
+	return &stopError{err: err}
+}
+
+type retryAfterError struct {
+	err   error
+	delay time.Duration
+}
+
+func (e *retryAfterError) Error() string { return e.err.Error() }
+func (e *retryAfterError) Unwrap() error { return e.err }
+
+// RetryAfter wraps the error returned by a job to run it again once the delay
+// passed instead of following its retry policy. The attempt is not counted,
+// as it is meant for errors caused by something else than the job, like the
+// API throttling requests.
+func RetryAfter(err error, delay time.Duration) error {
+	return &retryAfterError{err: err, delay: delay}
+}
+
+func (q *RegisteredJobs) retryOrFailJob(db *sqlx.DB, id int64, attempts int, policy RetryPolicy, jobErr error) (failed bool, err error) {
+	if attempts >= policy.MaxAttempts {
+		return true, q.markJobFailed(db, id, jobErr)
+	}
+
+	result, err := db.Exec(`
+	UPDATE jobs
+	SET
+		state = 'pending',
+		available_at = $3,
+		last_error = $4,
+		worker_id = NULL,
+		locked_until = NULL,
+		cancel_requested = FALSE
+	WHERE id = $1 AND worker_id = $2
+	`, id, q.config.InstanceId, time.Now().Add(policy.Backoff(attempts)), jobErr.Error())
+	if err != nil {
+		return false, err
+	}
+	return false, checkOwned(result)
+}
+
+// checkOwned returns errLeaseLost if an update of a job owned by this instance
+// matched no row, because another instance took the job over.
+func checkOwned(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected != 1 {
+		return errLeaseLost
+	}
+	return nil
+}
+
+// deferJob makes a job pending again after the delay, giving back its attempt.
+func (q *RegisteredJobs) deferJob(db *sqlx.DB, id int64, delay time.Duration, jobErr error) error {
+	result, err := db.Exec(`
+	UPDATE jobs
+	SET
+		state = 'pending',
+		available_at = $3,
+		last_error = $4,
+		attempts = GREATEST(attempts - 1, 0),
+		worker_id = NULL,
+		locked_until = NULL,
+		cancel_requested = FALSE
+	WHERE id = $1 AND worker_id = $2
+	`, id, q.config.InstanceId, time.Now().Add(delay), jobErr.Error())
+	if err != nil {
+		return err
+	}
+	return checkOwned(result)
+}
+
+// interruptJob puts a job stopped by the shutdown back to pending, giving back
+// the attempt it was running as.
+func (q *RegisteredJobs) interruptJob(db *sqlx.DB, id int64) error {
+	result, err := db.Exec(`
+	UPDATE jobs
+	SET
+		state = 'pending',
+		attempts = GREATEST(attempts - 1, 0),
+		worker_id = NULL,
+		locked_until = NULL
+	WHERE id = $1 AND worker_id = $2 AND state = 'running'
+	`, id, q.config.InstanceId)
+	if err != nil {
+		return err
+	}
+	return checkOwned(result)
+}
+
+func (q *RegisteredJobs) markJobFailed(db *sqlx.DB, id int64, jobErr error) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+	UPDATE jobs
+	SET state = 'failed', last_error = $3, worker_id = NULL, locked_until = NULL, cancel_requested = FALSE
+	WHERE id = $1 AND worker_id = $2
+	`, id, q.config.InstanceId, jobErr.Error())
+	if err != nil {
+		return err
+	}
+	if err := checkOwned(result); err != nil {
+		return err
+	}
+	if err := failDependents(tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const activeUniqueKeyCondition = `
+	SELECT 1
+	FROM jobs active
+	WHERE active.unique_key = failed.unique_key AND active.state IN ('pending', 'queued', 'running', 'blocked')
+`
+
+// Requeued jobs wait again for the dependencies they have not seen finish
+const requeuedState = `
+	CASE
+		WHEN EXISTS (SELECT 1 FROM job_dependencies WHERE job_dependencies.job_id = jobs.id) THEN 'blocked'::job_state
+		ELSE 'pending'::job_state
+	END`
+
+// RequeueFailedJobs moves failed jobs back to pending with their attempts reset,
+// or back to blocked if they still wait on dependencies. An empty name requeues the failed jobs of every kind. Failed unique jobs are
+// left alone if an equivalent job is already active, and only the most recent
+// failure of each unique key is requeued.
+func RequeueFailedJobs(db *sqlx.DB, name string) (int64, error) {
+	result, err := db.Exec(`
+	UPDATE jobs
+	SET state = `+requeuedState+`, attempts = 0, available_at = CURRENT_TIMESTAMP
+	WHERE id IN (
+		SELECT id
+		FROM jobs
+		WHERE state = 'failed' AND ($1 = '' OR name = $1) AND unique_key IS NULL
+		UNION ALL
+		(
+			SELECT DISTINCT ON (unique_key) id
+			FROM jobs failed
+			WHERE
+				state = 'failed'
+				AND ($1 = '' OR name = $1)
+				AND unique_key IS NOT NULL
+				AND NOT EXISTS (`+activeUniqueKeyCondition+`)
+			ORDER BY unique_key, id DESC
+		)
+	)
+	`, name)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func RequeueJob(db *sqlx.DB, id int64) (bool, error) {
+	result, err := db.Exec(`
+	UPDATE jobs
+	SET state = `+requeuedState+`, attempts = 0, available_at = CURRENT_TIMESTAMP
+	FROM jobs failed
+	WHERE
+		jobs.id = failed.id
+		AND failed.state = 'failed'
+		AND failed.id = $1
+		AND (failed.unique_key IS NULL OR NOT EXISTS (`+activeUniqueKeyCondition+`))
+	`, id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
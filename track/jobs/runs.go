@@ -0,0 +1,185 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type JobRunOutcome string
+
+var (
+	// The job finished and reported success
+	JobRunSucceeded JobRunOutcome = "succeeded"
+	// The job finished but reported it could not do its work
+	JobRunUnsuccessful JobRunOutcome = "unsuccessful"
+	// The job returned an error and will be retried
+	JobRunRetrying JobRunOutcome = "retrying"
+	// The job returned an error and ran out of attempts
+	JobRunFailed JobRunOutcome = "failed"
+	// The job was stopped because the job loop was shutting down
+	JobRunInterrupted JobRunOutcome = "interrupted"
+	// The instance lost the lease of the job while it was running
+	JobRunLeaseLost JobRunOutcome = "lease_lost"
+	// The job was cancelled while it was running
+	JobRunCancelled JobRunOutcome = "cancelled"
+	// The job returned an error wrapped by StopJob and was failed without retries
+	JobRunStopped JobRunOutcome = "stopped"
+	// The job returned an error wrapped by RetryAfter and will run again later
+	JobRunDeferred JobRunOutcome = "deferred"
+)
+
+// JobRun records a single execution of a job. Runs outlive the job row, which
+// is deleted or reused once the job finishes.
+type JobRun struct {
+	Id         int64         `db:"id"`
+	JobId      int64         `db:"job_id"`
+	Name       string        `db:"name"`
+	Attempt    int           `db:"attempt"`
+	WorkerId   string        `db:"worker_id"`
+	StartedAt  time.Time     `db:"started_at"`
+	FinishedAt time.Time     `db:"finished_at"`
+	Outcome    JobRunOutcome `db:"outcome"`
+	Error      *string       `db:"error"`
+	// Number of requests made through the JobRunContext, including cache hits
+	HttpCalls   int   `db:"http_calls"`
+	CacheHits   int   `db:"cache_hits"`
+	RowsWritten int64 `db:"rows_written"`
+}
+
+func (r JobRun) Duration() time.Duration {
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+// runRecorder wraps the JobRunContext given to a job to count its requests and
+// make the jobs it enqueues its children.
+type runRecorder struct {
+	JobRunContext
+	jobId     int64
+	name      string
+	httpCalls atomic.Int64
+	cacheHits atomic.Int64
+}
+
+func (r *runRecorder) BeginTx(ctx context.Context) (*JobTx, error) {
+	tx, err := r.JobRunContext.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tx.parentId = r.jobId
+	return tx, nil
+}
+
+func (r *runRecorder) Get(ctx context.Context, url string) (*http.Response, bool, error) {
+	response, cacheHit, err := r.JobRunContext.Get(withJobName(ctx, r.name), url)
+	r.httpCalls.Add(1)
+	if cacheHit {
+		r.cacheHits.Add(1)
+	}
+	return response, cacheHit, err
+}
+
+// Post forwards POST requests if the wrapped context can send them.
+func (r *runRecorder) Post(ctx context.Context, url string, body io.Reader) (*http.Response, error) {
+	poster, ok := r.JobRunContext.(interface {
+		Post(ctx context.Context, url string, body io.Reader) (*http.Response, error)
+	})
+	if !ok {
+		return nil, errors.New("job run context can not send POST requests")
+	}
+	r.httpCalls.Add(1)
+	return poster.Post(withJobName(ctx, r.name), url, body)
+}
+
+type jobNameKey struct{}
+
+func withJobName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, jobNameKey{}, name)
+}
+
+// JobNameFromContext returns the kind of the job a request is made for, if it
+// is made through the JobRunContext of a job.
+func JobNameFromContext(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(jobNameKey{}).(string)
+	return name, ok
+}
+
+func (q *RegisteredJobs) recordJobRun(db *sqlx.DB, logger *slog.Logger, run *JobRun) {
+	_, err := db.NamedExec(`
+	INSERT INTO job_runs (job_id, name, attempt, worker_id, started_at, finished_at, outcome, error, http_calls, cache_hits, rows_written)
+	VALUES (:job_id, :name, :attempt, :worker_id, :started_at, :finished_at, :outcome, :error, :http_calls, :cache_hits, :rows_written)
+	`, run)
+	if err != nil {
+		logger.Error("Error recording job run", "err", err)
+	}
+}
+
+// PruneJobRuns deletes the runs that finished before the given time.
+func PruneJobRuns(db *sqlx.DB, before time.Time) (int64, error) {
+	result, err := db.Exec("DELETE FROM job_runs WHERE finished_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (q *RegisteredJobs) pruneJobRuns(db *sqlx.DB, logger *slog.Logger) {
+	if q.config.RunRetention <= 0 {
+		return
+	}
+	pruned, err := PruneJobRuns(db, time.Now().Add(-q.config.RunRetention))
+	if err != nil {
+		logger.Error("Error pruning job runs", "err", err)
+		return
+	}
+	if pruned > 0 {
+		logger.Debug("Pruned old job runs", "runs", pruned)
+	}
+}
+
+// LastSuccessfulRun returns the most recent successful run of a job kind, or
+// nil if it never succeeded.
+func LastSuccessfulRun(db *sqlx.DB, name string) (*JobRun, error) {
+	runs, err := ListJobRuns(db, JobRunFilter{Name: name, Outcome: JobRunSucceeded, Limit: 1})
+	if err != nil || len(runs) == 0 {
+		return nil, err
+	}
+	return &runs[0], nil
+}
+
+type JobRunFilter struct {
+	// Only runs of this job kind, any kind if empty
+	Name string
+	// Only runs of this job, any job if zero
+	JobId int64
+	// Only runs with this outcome, any outcome if empty
+	Outcome JobRunOutcome
+	// Maximum number of runs returned, all of them if zero
+	Limit int
+}
+
+// ListJobRuns returns the runs matching the filter, most recent first.
+func ListJobRuns(db *sqlx.DB, filter JobRunFilter) ([]JobRun, error) {
+	var limit *int
+	if filter.Limit > 0 {
+		limit = &filter.Limit
+	}
+	runs := []JobRun{}
+	err := db.Select(&runs, `
+	SELECT *
+	FROM job_runs
+	WHERE
+		($1::TEXT = '' OR name = $1::TEXT)
+		AND ($2::BIGINT = 0 OR job_id = $2::BIGINT)
+		AND ($3::TEXT = '' OR outcome::TEXT = $3::TEXT)
+	ORDER BY started_at DESC, id DESC
+	LIMIT $4::INTEGER
+	`, filter.Name, filter.JobId, string(filter.Outcome), limit)
+	return runs, err
+}
@@ -0,0 +1,133 @@
+package jobs_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	testutil "github.com/MrNemo64/coc-tracker/test_util"
+	"github.com/MrNemo64/coc-tracker/track/jobs"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+type requestingMockJob struct {
+	provider *requestingMockJobProvider
+}
+
+func (j *requestingMockJob) Run(jctx jobs.JobRunContext, ctx context.Context) (*jobs.JobFinishInformation, error) {
+	for _, url := range j.provider.urls {
+		if _, _, err := jctx.Get(ctx, url); err != nil {
+			return nil, err
+		}
+	}
+	return j.provider.run(ctx, "")
+}
+
+func (j *requestingMockJob) Serialize(*sqlx.DB) error { return nil }
+
+type requestingMockJobProvider struct {
+	mockJobProvider
+	urls []string
+}
+
+func (p *requestingMockJobProvider) Deserialize(string) (jobs.Job, error) {
+	return &requestingMockJob{provider: p}, nil
+}
+
+func TestJobRuns(t *testing.T) {
+	t.Parallel()
+
+	container, err := testutil.CreatePostgresContainer()
+	if err != nil {
+		t.Fatalf("Could not set up test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			t.Errorf("Error shuting down test container: %v", err)
+		}
+	})
+
+	for _, name := range []string{"mock/Requests", "mock/Failing"} {
+		if _, err := container.DB.Exec("INSERT INTO jobs (name) VALUES ($1)", name); err != nil {
+			t.Fatalf("Error inserting job %s: %v", name, err)
+		}
+	}
+
+	providers := jobs.NewJobQueue()
+	providers.RegisterJobKind(&requestingMockJobProvider{
+		mockJobProvider: mockJobProvider{
+			name: "mock/Requests",
+			run: func(context.Context, string) (*jobs.JobFinishInformation, error) {
+				return &jobs.JobFinishInformation{Successfull: true, RowsWritten: 3}, nil
+			},
+		},
+		urls: []string{"/cached", "/fresh", "/cached"},
+	})
+	providers.RegisterJobKind(&mockJobProvider{
+		name:  "mock/Failing",
+		retry: jobs.RetryPolicy{MaxAttempts: 1},
+		run: func(context.Context, string) (*jobs.JobFinishInformation, error) {
+			return nil, fmt.Errorf("boom")
+		},
+	})
+
+	jctx := &mockJobRunContext{
+		db: container.DB,
+		get: func(url string) (*http.Response, bool, error) {
+			return &http.Response{StatusCode: 200}, url == "/cached", nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	loopDone := make(chan struct{})
+	go func() {
+		defer close(loopDone)
+		providers.RunJobLoop(jctx, testutil.MakeTestLogger().Logger, ctx)
+	}()
+
+	var runs []jobs.JobRun
+	deadline := time.Now().Add(5 * time.Second)
+	for len(runs) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for job runs, got %v", runs)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if runs, err = jobs.ListJobRuns(container.DB, jobs.JobRunFilter{}); err != nil {
+			t.Fatalf("Error listing job runs: %v", err)
+		}
+	}
+	cancel()
+	<-loopDone
+
+	succeeded, err := jobs.LastSuccessfulRun(container.DB, "mock/Requests")
+	assert.NoError(t, err)
+	if assert.NotNil(t, succeeded) {
+		assert.Equal(t, int64(1), succeeded.JobId)
+		assert.Equal(t, 1, succeeded.Attempt)
+		assert.Equal(t, 3, succeeded.HttpCalls)
+		assert.Equal(t, 2, succeeded.CacheHits)
+		assert.Equal(t, int64(3), succeeded.RowsWritten)
+		assert.Nil(t, succeeded.Error)
+		assert.False(t, succeeded.FinishedAt.Before(succeeded.StartedAt))
+	}
+
+	failed, err := jobs.ListJobRuns(container.DB, jobs.JobRunFilter{Name: "mock/Failing"})
+	assert.NoError(t, err)
+	if assert.Len(t, failed, 1) {
+		assert.Equal(t, jobs.JobRunFailed, failed[0].Outcome)
+		if assert.NotNil(t, failed[0].Error) {
+			assert.Equal(t, "boom", *failed[0].Error)
+		}
+	}
+
+	never, err := jobs.LastSuccessfulRun(container.DB, "mock/Failing")
+	assert.NoError(t, err)
+	assert.Nil(t, never)
+
+	pruned, err := jobs.PruneJobRuns(container.DB, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), pruned)
+}
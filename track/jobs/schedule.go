@@ -0,0 +1,286 @@
+package jobs
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Schedule computes when a recurring job has to run next.
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+// ScheduledProvider can be implemented by a JobProvider whose jobs recur. The
+// job loop keeps exactly one pending job of the kind, rescheduling it after
+// every run according to the schedule. A nil schedule means the kind does not recur.
+type ScheduledProvider interface {
+	Schedule() Schedule
+}
+
+func scheduleOf(provider JobProvider) Schedule {
+	if p, ok := provider.(ScheduledProvider); ok {
+		return p.Schedule()
+	}
+	return nil
+}
+
+type intervalSchedule struct {
+	interval time.Duration
+}
+
+// Every creates a schedule that runs a job a fixed amount of time after the
+// previous run finished.
+func Every(interval time.Duration) Schedule {
+	return intervalSchedule{interval: interval}
+}
+
+func (s intervalSchedule) Next(after time.Time) time.Time {
+	return after.Add(s.interval)
+}
+
+type cronSchedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+	// When both day fields are restricted a day matching either of them is valid
+	anyDay   bool
+	location *time.Location
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField     = cronField{name: "minute", min: 0, max: 59}
+	hourField       = cronField{name: "hour", min: 0, max: 23}
+	dayOfMonthField = cronField{name: "day of month", min: 1, max: 31}
+	monthField      = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dayOfWeekField = cronField{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// ParseCron parses a standard five field cron expression (minute, hour, day of
+// month, month and day of week) evaluated in the given location, UTC if nil.
+// The @hourly, @daily, @weekly, @monthly and @yearly descriptors and
+// "@every <duration>" are also accepted.
+func ParseCron(expression string, location *time.Location) (Schedule, error) {
+	if location == nil {
+		location = time.UTC
+	}
+
+	expression = strings.TrimSpace(expression)
+	if every, ok := strings.CutPrefix(expression, "@every "); ok {
+		interval, err := time.ParseDuration(strings.TrimSpace(every))
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval in cron expression %q: %w", expression, err)
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("interval in cron expression %q must be positive", expression)
+		}
+		return Every(interval), nil
+	}
+	if descriptor, ok := cronDescriptors[strings.ToLower(expression)]; ok {
+		expression = descriptor
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields, got %d", expression, len(fields))
+	}
+
+	schedule := &cronSchedule{location: location}
+	var err error
+	targets := []*uint64{&schedule.minute, &schedule.hour, &schedule.dayOfMonth, &schedule.month, &schedule.dayOfWeek}
+	for i, field := range []cronField{minuteField, hourField, dayOfMonthField, monthField, dayOfWeekField} {
+		if *targets[i], err = field.parse(fields[i]); err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", expression, err)
+		}
+	}
+	// Both 0 and 7 are sunday
+	if schedule.dayOfWeek&(1<<7) != 0 {
+		schedule.dayOfWeek |= 1
+	}
+	schedule.anyDay = fields[2] != "*" && fields[4] != "*"
+	return schedule, nil
+}
+
+func (f cronField) parse(field string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepPart, f.name)
+			}
+		}
+
+		var from, to int
+		if rangePart == "*" {
+			from, to = f.min, f.max
+		} else {
+			start, end, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if from, err = f.value(start); err != nil {
+				return 0, err
+			}
+			to = from
+			if isRange {
+				if to, err = f.value(end); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				to = f.max
+			}
+			if from > to {
+				return 0, fmt.Errorf("invalid range %q in %s field", rangePart, f.name)
+			}
+		}
+
+		for value := from; value <= to; value += step {
+			bits |= 1 << value
+		}
+	}
+	return bits, nil
+}
+
+func (f cronField) value(text string) (int, error) {
+	if value, ok := f.names[strings.ToLower(text)]; ok {
+		return value, nil
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil || value < f.min || value > f.max {
+		return 0, fmt.Errorf("invalid value %q in %s field, must be between %d and %d", text, f.name, f.min, f.max)
+	}
+	return value, nil
+}
+
+func (s *cronSchedule) Next(after time.Time) time.Time {
+	t := after.In(s.location).Truncate(time.Minute).Add(time.Minute)
+	// Every valid expression matches at least once every 4 years
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.location)
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.location)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.location)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	dayOfMonth := s.dayOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := s.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	if s.anyDay {
+		return dayOfMonth || dayOfWeek
+	}
+	return dayOfMonth && dayOfWeek
+}
+
+// ensureScheduled leaves exactly one pending or running job of a recurring kind.
+// A kind that was never scheduled runs right away, while a kind whose only jobs
+// have failed gets a new job at its next scheduled time.
+func ensureScheduled(db *sqlx.DB, provider JobProvider, schedule Schedule) error {
+	name := provider.JobName()
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", "jobs/schedule/"+name); err != nil {
+		return err
+	}
+
+	var counts struct {
+		Pending int `db:"pending"`
+		Active  int `db:"active"`
+		Total   int `db:"total"`
+	}
+	err = tx.Get(&counts, `
+	SELECT
+		COUNT(*) FILTER (WHERE state = 'pending') AS pending,
+		COUNT(*) FILTER (WHERE state IN ('queued', 'running')) AS active,
+		COUNT(*) AS total
+	FROM jobs
+	WHERE name = $1
+	`, name)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case counts.Active > 0 && counts.Pending > 0:
+		// The active job reschedules itself once it finishes
+		_, err = tx.Exec("DELETE FROM jobs WHERE name = $1 AND state = 'pending'", name)
+	case counts.Pending > 1:
+		_, err = tx.Exec(`
+		DELETE FROM jobs
+		WHERE name = $1 AND state = 'pending' AND id <> (
+			SELECT id
+			FROM jobs
+			WHERE name = $1 AND state = 'pending'
+			ORDER BY available_at ASC, id ASC
+			LIMIT 1
+		)
+		`, name)
+	case counts.Pending == 0 && counts.Active == 0:
+		at := time.Now()
+		if counts.Total > 0 {
+			at = schedule.Next(at)
+		}
+		priority := defaultPriorityOf(provider)
+		_, err = Enqueue(tx, name, nil, EnqueueOptions{At: at, Priority: &priority, Unique: true})
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (q *RegisteredJobs) ensureSchedules(db *sqlx.DB, logger *slog.Logger) {
+	for name, provider := range q.providers {
+		if schedule := scheduleOf(provider); schedule != nil {
+			if err := ensureScheduled(db, provider, schedule); err != nil {
+				logger.Error("Error ensuring recurring job is scheduled", "job-name", name, "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package jobs_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	testutil "github.com/MrNemo64/coc-tracker/test_util"
+	"github.com/MrNemo64/coc-tracker/track/jobs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCron(t *testing.T) {
+	t.Parallel()
+
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	if err != nil {
+		t.Fatalf("Could not load location: %v", err)
+	}
+	from := time.Date(2024, time.May, 15, 10, 30, 0, 0, time.UTC) // Wednesday
+
+	for _, test := range []struct {
+		expression string
+		location   *time.Location
+		expected   time.Time
+	}{
+		{"*/15 * * * *", nil, time.Date(2024, time.May, 15, 10, 45, 0, 0, time.UTC)},
+		{"0 * * * *", nil, time.Date(2024, time.May, 15, 11, 0, 0, 0, time.UTC)},
+		{"30 10 * * *", nil, time.Date(2024, time.May, 16, 10, 30, 0, 0, time.UTC)},
+		{"0 6 * * mon", nil, time.Date(2024, time.May, 20, 6, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan-mar *", nil, time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", nil, time.Date(2024, time.May, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", nil, time.Date(2024, time.May, 19, 0, 0, 0, 0, time.UTC)},
+		{"@daily", nil, time.Date(2024, time.May, 16, 0, 0, 0, 0, time.UTC)},
+		{"@every 90m", nil, time.Date(2024, time.May, 15, 12, 0, 0, 0, time.UTC)},
+		{"0 9 * * *", madrid, time.Date(2024, time.May, 16, 7, 0, 0, 0, time.UTC)},
+	} {
+		schedule, err := jobs.ParseCron(test.expression, test.location)
+		if !assert.NoError(t, err, test.expression) {
+			continue
+		}
+		assert.True(t, test.expected.Equal(schedule.Next(from)), "%s: expected %s but got %s", test.expression, test.expected, schedule.Next(from))
+	}
+
+	for _, invalid := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "@every -1m", "* * * foo *"} {
+		_, err := jobs.ParseCron(invalid, nil)
+		assert.Error(t, err, "Expression %q should be invalid", invalid)
+	}
+}
+
+type scheduledMockJobProvider struct {
+	mockJobProvider
+	schedule jobs.Schedule
+}
+
+func (p *scheduledMockJobProvider) Schedule() jobs.Schedule { return p.schedule }
+
+func TestScheduledJobs(t *testing.T) {
+	t.Parallel()
+
+	container, err := testutil.CreatePostgresContainer()
+	if err != nil {
+		t.Fatalf("Could not set up test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			t.Errorf("Error shuting down test container: %v", err)
+		}
+	})
+
+	ran := make(chan struct{}, 1)
+	providers := jobs.NewJobQueue()
+	providers.RegisterJobKind(&scheduledMockJobProvider{
+		mockJobProvider: mockJobProvider{
+			name: "mock/Seeded",
+			run: func(context.Context, string) (*jobs.JobFinishInformation, error) {
+				ran <- struct{}{}
+				return &jobs.JobFinishInformation{Successfull: true}, nil
+			},
+		},
+		schedule: jobs.Every(time.Hour),
+	})
+	providers.RegisterJobKind(&scheduledMockJobProvider{
+		mockJobProvider: mockJobProvider{name: "mock/Duplicated"},
+		schedule:        jobs.Every(time.Hour),
+	})
+
+	for _, at := range []time.Time{time.Now().Add(2 * time.Hour), time.Now().Add(time.Hour)} {
+		if _, err := container.DB.Exec("INSERT INTO jobs (name, available_at) VALUES ('mock/Duplicated', $1)", at); err != nil {
+			t.Fatalf("Error inserting job: %v", err)
+		}
+	}
+
+	assert.NoError(t, providers.CheckJobs(container.DB))
+	// Checking again must not create more jobs
+	assert.NoError(t, providers.CheckJobs(container.DB))
+
+	var names []string
+	if err := container.DB.Select(&names, "SELECT name FROM jobs ORDER BY id"); err != nil {
+		t.Fatalf("Could not read jobs: %v", err)
+	}
+	assert.Equal(t, []string{"mock/Duplicated", "mock/Seeded"}, names)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	loopDone := make(chan struct{})
+	go func() {
+		defer close(loopDone)
+		providers.RunJobLoop(&mockJobRunContext{db: container.DB}, testutil.MakeTestLogger().Logger, ctx)
+	}()
+
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the seeded job to run")
+	}
+	ranAt := time.Now()
+
+	waitForJobRuns(t, container.DB, 1)
+	assert.Eventually(t, func() bool {
+		var pending int
+		container.DB.Get(&pending, "SELECT COUNT(*) FROM jobs WHERE name = 'mock/Seeded' AND state = 'pending'")
+		return pending == 1
+	}, 5*time.Second, 20*time.Millisecond, "The recurring job was not rescheduled")
+	cancel()
+	<-loopDone
+
+	var seeded jobs.DBJob
+	if err := container.DB.Get(&seeded, "SELECT * FROM jobs WHERE name = 'mock/Seeded'"); err != nil {
+		t.Fatalf("Could not read the seeded job: %v", err)
+	}
+	assert.Equal(t, int64(3), seeded.Id, "The recurring job must reuse its row")
+	assert.Equal(t, jobs.JobStatePending, seeded.State)
+	assert.Equal(t, 0, seeded.Attempts)
+	assert.WithinDuration(t, ranAt.Add(time.Hour), seeded.AvailableAt, time.Second)
+}
@@ -0,0 +1,157 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// TypedJob is the payload of a job kind managed by a TypedProvider. The payload
+// is stored as JSON in the data column of the job, so its exported fields are
+// all the state a run gets.
+type TypedJob interface {
+	Run(JobRunContext, context.Context) (*JobFinishInformation, error)
+}
+
+// Validator can be implemented by a TypedJob to reject invalid payloads, both
+// when they are enqueued and when they are read back from the database.
+type Validator interface {
+	Validate() error
+}
+
+// ProviderOptions declares how the jobs of a TypedProvider are run. Zero values
+// fall back to the defaults of the job loop.
+type ProviderOptions struct {
+	Priority       int
+	MaxConcurrency int
+	Timeout        time.Duration
+	Retry          RetryPolicy
+	// Makes the kind recur, see ScheduledProvider
+	Schedule Schedule
+	// Makes every job of the kind unique, see EnqueueOptions
+	Unique bool
+	// Conflict strategy of unique jobs when the enqueue does not choose one
+	OnConflict ConflictStrategy
+}
+
+// TypedProvider is a JobProvider for jobs whose payload is the struct T, so a
+// new job kind only needs the struct and its Run method.
+type TypedProvider[T TypedJob] struct {
+	name    string
+	options ProviderOptions
+}
+
+func NewTypedProvider[T TypedJob](name string, options ProviderOptions) *TypedProvider[T] {
+	return &TypedProvider[T]{name: name, options: options}
+}
+
+type typedJob[T TypedJob] struct {
+	provider *TypedProvider[T]
+	payload  T
+}
+
+func (j *typedJob[T]) Run(jctx JobRunContext, ctx context.Context) (*JobFinishInformation, error) {
+	return j.payload.Run(jctx, ctx)
+}
+
+func (j *typedJob[T]) Serialize(db *sqlx.DB) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := j.provider.Enqueue(context.Background(), tx, j.payload, EnqueueOptions{}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Enqueue validates the payload and inserts a new job with it, applying the
+// priority and uniqueness declared in the provider options.
+func (p *TypedProvider[T]) Enqueue(ctx context.Context, tx *sqlx.Tx, payload T, opts EnqueueOptions) (int64, error) {
+	if err := validatePayload(payload); err != nil {
+		return 0, fmt.Errorf("invalid %s job: %w", p.name, err)
+	}
+	if opts.Priority == nil {
+		priority := p.options.Priority
+		opts.Priority = &priority
+	}
+	if p.options.Unique && !opts.Unique {
+		opts.Unique = true
+		opts.OnConflict = p.options.OnConflict
+	}
+	return enqueue(ctx, tx, p.name, payload, opts)
+}
+
+type dataEnqueuer interface {
+	enqueueData(ctx context.Context, tx *sqlx.Tx, data any, opts EnqueueOptions) (int64, error)
+}
+
+// enqueueData enqueues a job from data that is either a T or its encoding, so
+// enqueueing a typed job by name is validated as well.
+func (p *TypedProvider[T]) enqueueData(ctx context.Context, tx *sqlx.Tx, data any, opts EnqueueOptions) (int64, error) {
+	payload, ok := data.(T)
+	if !ok {
+		encoded, err := encodeJobData(data)
+		if err != nil {
+			return 0, err
+		}
+		if payload, err = p.Decode(encoded); err != nil {
+			return 0, err
+		}
+	}
+	return p.Enqueue(ctx, tx, payload, opts)
+}
+
+// Decode reads and validates a payload stored in the data column of a job.
+func (p *TypedProvider[T]) Decode(data string) (T, error) {
+	var payload T
+	if err := json.Unmarshal([]byte(data), &payload); err != nil {
+		return payload, fmt.Errorf("error decoding %s job: %w", p.name, err)
+	}
+	if err := validatePayload(payload); err != nil {
+		return payload, fmt.Errorf("invalid %s job: %w", p.name, err)
+	}
+	return payload, nil
+}
+
+func validatePayload(payload any) error {
+	if validator, ok := payload.(Validator); ok {
+		return validator.Validate()
+	}
+	return nil
+}
+
+func (p *TypedProvider[T]) Deserialize(data string) (Job, error) {
+	payload, err := p.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return &typedJob[T]{provider: p, payload: payload}, nil
+}
+
+// Save enqueues the job a run asked to reschedule. The data of the schedule
+// information must be a T, or nil to use its zero value.
+func (p *TypedProvider[T]) Save(tx *sqlx.Tx, info *ScheduleInformation) error {
+	var payload T
+	switch data := info.Data.(type) {
+	case nil:
+	case T:
+		payload = data
+	default:
+		return fmt.Errorf("cannot reschedule %s job with data of type %T", p.name, info.Data)
+	}
+	_, err := p.Enqueue(context.Background(), tx, payload, EnqueueOptions{At: info.At})
+	return err
+}
+
+func (p *TypedProvider[T]) JobName() string          { return p.name }
+func (p *TypedProvider[T]) DefaultPriority() int     { return p.options.Priority }
+func (p *TypedProvider[T]) MaxConcurrency() int      { return p.options.MaxConcurrency }
+func (p *TypedProvider[T]) Timeout() time.Duration   { return p.options.Timeout }
+func (p *TypedProvider[T]) RetryPolicy() RetryPolicy { return p.options.Retry }
+func (p *TypedProvider[T]) Schedule() Schedule       { return p.options.Schedule }
@@ -0,0 +1,97 @@
+package jobs_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	testutil "github.com/MrNemo64/coc-tracker/test_util"
+	"github.com/MrNemo64/coc-tracker/track/jobs"
+	"github.com/stretchr/testify/assert"
+)
+
+type refreshClanJob struct {
+	Tag   string `json:"tag"`
+	Depth int    `json:"depth,omitempty"`
+}
+
+func (j refreshClanJob) Validate() error {
+	if j.Tag == "" {
+		return fmt.Errorf("missing clan tag")
+	}
+	return nil
+}
+
+func (j refreshClanJob) Run(jobs.JobRunContext, context.Context) (*jobs.JobFinishInformation, error) {
+	return &jobs.JobFinishInformation{Successfull: true, RowsWritten: int64(j.Depth)}, nil
+}
+
+func TestTypedProviderDeserialize(t *testing.T) {
+	t.Parallel()
+
+	provider := jobs.NewTypedProvider[refreshClanJob]("update/Clan", jobs.ProviderOptions{
+		Priority: 5,
+		Timeout:  time.Minute,
+		Retry:    jobs.RetryPolicy{MaxAttempts: 3},
+	})
+
+	job, err := provider.Deserialize(`{"tag": "#ABC", "depth": 2}`)
+	if assert.NoError(t, err) {
+		info, err := job.Run(nil, context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, &jobs.JobFinishInformation{Successfull: true, RowsWritten: 2}, info)
+	}
+
+	_, err = provider.Deserialize(`{"depth": 2}`)
+	assert.ErrorContains(t, err, "missing clan tag")
+	_, err = provider.Deserialize(`{"tag": 5}`)
+	assert.Error(t, err)
+
+	var asProvider jobs.JobProvider = provider
+	assert.Equal(t, "update/Clan", asProvider.JobName())
+	assert.Equal(t, 5, asProvider.(jobs.PriorityProvider).DefaultPriority())
+	assert.Equal(t, time.Minute, asProvider.(jobs.TimeoutProvider).Timeout())
+	assert.Equal(t, 3, asProvider.(jobs.RetryPolicyProvider).RetryPolicy().MaxAttempts)
+	assert.Nil(t, asProvider.(jobs.ScheduledProvider).Schedule())
+}
+
+func TestTypedProviderEnqueue(t *testing.T) {
+	t.Parallel()
+
+	container, err := testutil.CreatePostgresContainer()
+	if err != nil {
+		t.Fatalf("Could not set up test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			t.Errorf("Error shuting down test container: %v", err)
+		}
+	})
+
+	provider := jobs.NewTypedProvider[refreshClanJob]("update/Clan", jobs.ProviderOptions{Priority: 5, Unique: true})
+	tx, err := container.DB.Beginx()
+	if err != nil {
+		t.Fatalf("Could not begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	ctx := context.Background()
+	id, err := provider.Enqueue(ctx, tx, refreshClanJob{Tag: "#ABC"}, jobs.EnqueueOptions{})
+	assert.NoError(t, err)
+	again, err := provider.Enqueue(ctx, tx, refreshClanJob{Tag: "#ABC"}, jobs.EnqueueOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, id, again)
+
+	_, err = provider.Enqueue(ctx, tx, refreshClanJob{}, jobs.EnqueueOptions{})
+	assert.ErrorContains(t, err, "missing clan tag")
+
+	var job jobs.DBJob
+	if err := tx.Get(&job, "SELECT * FROM jobs WHERE id = $1", id); err != nil {
+		t.Fatalf("Could not read job: %v", err)
+	}
+	assert.Equal(t, 5, job.Priority)
+	payload, err := provider.Decode(job.Data)
+	assert.NoError(t, err)
+	assert.Equal(t, refreshClanJob{Tag: "#ABC"}, payload)
+}
@@ -2,146 +2,110 @@ package update
 
 import (
 	"context"
-	"encoding/json"
 	"time"
 
+	"github.com/MrNemo64/coc-tracker/coc"
 	"github.com/MrNemo64/coc-tracker/track/jobs"
-	"github.com/MrNemo64/coc-tracker/util"
-	"github.com/jmoiron/sqlx"
 )
 
 type FetchCapitalLeagues struct{}
-type FetchCapitalLeaguesProvider struct{}
 
-func insertUpdateCapitalLeaguesJob(tx *sqlx.Tx, at *time.Time) error {
-	defer tx.Rollback()
-
-	_, err := tx.Exec("DELETE FROM jobs WHERE name = $1", "update/FetchCapitalLeagues")
-	if err != nil {
-		return err
-	}
-
-	if at == nil {
-		_, err = tx.Exec("INSERT INTO jobs (name) VALUES ($1)", "update/FetchCapitalLeagues")
-		if err != nil {
-			return err
-		}
-	} else {
-		_, err = tx.Exec("INSERT INTO jobs (name, available_at) VALUES ($1, $2)", "update/FetchCapitalLeagues", at)
-		if err != nil {
-			return err
-		}
-	}
-
-	return tx.Commit()
-}
+const fetchCapitalLeaguesName = "update/FetchCapitalLeagues"
 
-func NewFetchCapitalLeaguesProvider() *FetchCapitalLeaguesProvider {
-	return &FetchCapitalLeaguesProvider{}
+func NewFetchCapitalLeaguesProvider() *jobs.TypedProvider[FetchCapitalLeagues] {
+	return jobs.NewTypedProvider[FetchCapitalLeagues](fetchCapitalLeaguesName, jobs.ProviderOptions{
+		MaxConcurrency: 1,
+		Timeout:        time.Minute * 2,
+		Retry: jobs.RetryPolicy{
+			MaxAttempts: 10,
+			BaseDelay:   time.Minute,
+			MaxDelay:    time.Hour * 6,
+		},
+		Schedule:   jobs.Every(time.Hour * 24 * 7),
+		Unique:     true,
+		OnConflict: jobs.ConflictReplace,
+	})
 }
 
-func (*FetchCapitalLeagues) Run(jctx jobs.JobRunContext, c context.Context) (*jobs.JobFinishInformation, error) {
-	response, cacheHit, err := jctx.Get(c, util.CapitalLeagueEndpoint)
+func (FetchCapitalLeagues) Run(jctx jobs.JobRunContext, c context.Context) (*jobs.JobFinishInformation, error) {
+	// Pages served from the cache were fetched by a previous run, which may have
+	// failed before writing them. They are only skipped if that run succeeded.
+	stored, err := previousRunSucceeded(jctx, fetchCapitalLeaguesName)
 	if err != nil {
 		return nil, err
 	}
-	defer response.Body.Close()
-
-	if response.StatusCode != 200 {
-		return &jobs.JobFinishInformation{
-			Successfull: false,
-			Reschedule: &jobs.ScheduleInformation{
-				At: time.Now().Add(time.Hour * 1),
-			},
-		}, nil
-	}
-
-	if cacheHit {
-		return &jobs.JobFinishInformation{
-			Successfull: true,
-			Reschedule: &jobs.ScheduleInformation{
-				At: time.Now().Add(time.Hour * 24 * 7),
-			},
-		}, nil
+	pager := coc.Paginate(coc.NewClient(jctx).GetCapitalLeagues, coc.ListOptions{})
+	var pending [][]coc.League
+	fresh := false
+	for pager.Next(c) {
+		pending = append(pending, pager.Page())
+		if !stored || !pager.CacheHit() {
+			fresh = true
+			break
+		}
 	}
-
-	type TRes struct {
-		Leagues []struct {
-			Id   int    `json:"id" db:"id"`
-			Name string `json:"name" db:"name"`
-		} `json:"items"`
+	if err := pager.Err(); err != nil {
+		return nil, apiErrorPolicy(err)
 	}
-	var leagues TRes
-
-	if err = json.NewDecoder(response.Body).Decode(&leagues); err != nil {
-		return nil, err
+	if !fresh {
+		return &jobs.JobFinishInformation{Successfull: true}, nil
 	}
 
-	tx, err := jctx.GetDB().Beginx()
+	tx, err := jctx.BeginTx(c)
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
 
-	if err = tx.Commit(); err != nil {
-		return nil, err
-	}
-
-	stmt, err := tx.PrepareNamed(`
+	stmt, err := tx.PreparexContext(c, `
 	INSERT INTO capital_leagues (id, name)
-	VALUES (:id, :name)
+	VALUES ($1, $2)
 	ON CONFLICT (id)
-	DO UPDATE SET name = EXCLUDED.name;
+	DO UPDATE SET name = EXCLUDED.name
+	WHERE capital_leagues.name <> EXCLUDED.name;
 	`)
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
-	for _, league := range leagues.Leagues {
-		_, err := stmt.Exec(league)
-		if err != nil {
+	var written int64
+	write := func(leagues []coc.League) error {
+		for _, league := range leagues {
+			result, err := stmt.ExecContext(c, league.Id, league.Name)
+			if err != nil {
+				return err
+			}
+			affected, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			written += affected
+		}
+		return nil
+	}
+	for _, leagues := range pending {
+		if err := write(leagues); err != nil {
 			return nil, err
 		}
 	}
-
-	err = tx.Commit()
-	if err != nil {
-		return nil, err
+	if err := pager.Each(c, write); err != nil {
+		return nil, apiErrorPolicy(err)
 	}
 
-	return &jobs.JobFinishInformation{
-		Successfull: true,
-		Reschedule: &jobs.ScheduleInformation{
-			At: time.Now().Add(time.Hour * 24 * 7),
-		},
-	}, nil
-}
-
-func (*FetchCapitalLeagues) Serialize(db *sqlx.DB) error {
-	tx, err := db.Beginx()
-	if err != nil {
-		return err
+	if err := tx.Commit(); err != nil {
+		return nil, err
 	}
-	return insertUpdateCapitalLeaguesJob(tx, nil)
-}
 
-func (*FetchCapitalLeaguesProvider) Deserialize(_ string) (jobs.Job, error) {
-	return &FetchCapitalLeagues{}, nil
+	return &jobs.JobFinishInformation{Successfull: true, RowsWritten: written}, nil
 }
 
-func (*FetchCapitalLeaguesProvider) Save(db *sqlx.Tx, info *jobs.ScheduleInformation) error {
-	return insertUpdateCapitalLeaguesJob(db, &info.At)
-}
-
-func (j *FetchCapitalLeaguesProvider) CheckJobsTable(db *sqlx.DB) error {
-	tx, err := db.Beginx()
+// previousRunSucceeded reports whether the last run of a job kind finished
+// successfully, so the data it fetched is known to be stored.
+func previousRunSucceeded(jctx jobs.JobRunContext, name string) (bool, error) {
+	runs, err := jobs.ListJobRuns(jctx.GetDB(), jobs.JobRunFilter{Name: name, Limit: 1})
 	if err != nil {
-		return err
+		return false, err
 	}
-	return insertUpdateCapitalLeaguesJob(tx, nil)
-}
-
-func (*FetchCapitalLeaguesProvider) JobName() string {
-	return "update/FetchCapitalLeagues"
+	return len(runs) == 1 && runs[0].Outcome == jobs.JobRunSucceeded, nil
 }
@@ -0,0 +1,36 @@
+package update
+
+import (
+	"errors"
+	"time"
+
+	"github.com/MrNemo64/coc-tracker/coc"
+	"github.com/MrNemo64/coc-tracker/track/jobs"
+)
+
+const (
+	// How long jobs wait when the keys are throttled or rejected by the API
+	throttledRetryDelay = time.Minute
+	// How long jobs wait when the API is in maintenance
+	maintenanceRetryDelay = 5 * time.Minute
+)
+
+// apiErrorPolicy decides how a job reacts to an error of the API. Jobs tracking
+// a clan or player that no longer exists stop, and the errors caused by the keys
+// or by the API being unavailable are retried later without using up attempts.
+// Anything else is retried following the retry policy of the job.
+func apiErrorPolicy(err error) error {
+	var apiErr *coc.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	switch {
+	case errors.Is(apiErr, coc.ErrNotFound):
+		return jobs.StopJob(err)
+	case errors.Is(apiErr, coc.ErrMaintenance):
+		return jobs.RetryAfter(err, maintenanceRetryDelay)
+	case errors.Is(apiErr, coc.ErrThrottled), errors.Is(apiErr, coc.ErrInvalidIp):
+		return jobs.RetryAfter(err, max(apiErr.RetryAfter, throttledRetryDelay))
+	}
+	return err
+}
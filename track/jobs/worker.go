@@ -0,0 +1,350 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	errLeaseLost = errors.New("job lease lost")
+	errShutdown  = errors.New("job loop stopped")
+)
+
+// RunJobLoop runs jobs until ctx is cancelled. Jobs that are already running
+// get the configured grace period to finish before their context is cancelled,
+// and the loop returns once all of them finished or went back to pending.
+func (q *RegisteredJobs) RunJobLoop(jctx JobRunContext, logger *slog.Logger, ctx context.Context) {
+	jobChannel := make(chan DBJob, q.config.Workers)
+
+	runCtx, stopJobs := context.WithCancelCause(context.WithoutCancel(ctx))
+	defer stopJobs(nil)
+	stopGracePeriod := context.AfterFunc(ctx, func() {
+		time.AfterFunc(q.config.ShutdownGracePeriod, func() { stopJobs(errShutdown) })
+	})
+	defer stopGracePeriod()
+
+	q.reapExpiredLeases(jctx.GetDB(), logger)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		q.runMaintenance(jctx.GetDB(), logger, ctx)
+	}()
+
+	for i := 0; i < q.config.Workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			q.runWorker(jctx, logger.With("worker", worker), ctx, runCtx, jobChannel)
+		}(i)
+	}
+
+	if err := q.FetchAvailableJobs(jctx, logger, ctx, jobChannel); err != nil {
+		logger.Error("Error stopping job fetching", "err", err)
+	}
+
+	wg.Wait()
+	logger.Info("Job loop stopped")
+}
+
+func (q *RegisteredJobs) runWorker(jctx JobRunContext, logger *slog.Logger, ctx context.Context, runCtx context.Context, jobs <-chan DBJob) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-jobs:
+			if ctx.Err() != nil || !q.acquireKindSlot(ctx, job.Name) {
+				setJobsToPending([]int64{job.Id}, logger, jctx.GetDB())
+				return
+			}
+			if q.IsPaused(job.Name) || q.IsHeld() {
+				// The kind was paused or the queue held after the job was claimed
+				setJobsToPending([]int64{job.Id}, logger, jctx.GetDB())
+				q.releaseKindSlot(job.Name)
+				continue
+			}
+			q.executeJob(jctx, logger.With("job-id", job.Id, "job-name", job.Name), runCtx, job)
+			q.releaseKindSlot(job.Name)
+		}
+	}
+}
+
+func (q *RegisteredJobs) executeJob(jctx JobRunContext, logger *slog.Logger, ctx context.Context, dbJob DBJob) {
+	db := jctx.GetDB()
+
+	provider := q.FindJobProvider(dbJob.Name)
+	if provider == nil {
+		logger.Error("No provider registered for job, marking it as failed")
+		if err := q.markJobFailed(db, dbJob.Id, fmt.Errorf("no provider registered for job %s", dbJob.Name)); err != nil {
+			logger.Error("Error marking job as failed", "err", err)
+		}
+		return
+	}
+
+	job, err := provider.Deserialize(dbJob.Data)
+	if err != nil {
+		logger.Error("Error deserializing job, marking it as failed", "err", err)
+		if err := q.markJobFailed(db, dbJob.Id, fmt.Errorf("error deserializing job: %w", err)); err != nil {
+			logger.Error("Error marking job as failed", "err", err)
+		}
+		return
+	}
+
+	attempts, started, err := q.markJobRunning(db, dbJob.Id)
+	if err != nil {
+		logger.Error("Error marking job as running", "err", err)
+		setJobsToPending([]int64{dbJob.Id}, logger, db)
+		return
+	}
+	if !started {
+		logger.Warn("Job is no longer queued by this instance or was cancelled, skipping it")
+		return
+	}
+
+	runCtx, cancelRun := context.WithCancelCause(ctx)
+	defer cancelRun(nil)
+	q.trackRunning(dbJob.Id, cancelRun)
+	defer q.untrackRunning(dbJob.Id)
+	if timeout := q.timeoutOf(provider); timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		runCtx, cancelTimeout = context.WithTimeoutCause(runCtx, timeout, fmt.Errorf("job timed out after %s", timeout))
+		defer cancelTimeout()
+	}
+	var leaseLost atomic.Bool
+	stopHeartbeat := make(chan struct{})
+	go q.heartbeat(db, logger, dbJob.Id, stopHeartbeat, func(cause error) {
+		if errors.Is(cause, errLeaseLost) {
+			leaseLost.Store(true)
+		}
+		cancelRun(cause)
+	})
+
+	logger.Info("Running job", "attempt", attempts)
+	recorder := &runRecorder{JobRunContext: jctx, jobId: dbJob.Id, name: dbJob.Name}
+	startedAt := time.Now()
+	info, err := runJob(job, recorder, runCtx)
+	close(stopHeartbeat)
+
+	run := &JobRun{
+		JobId:      dbJob.Id,
+		Name:       dbJob.Name,
+		Attempt:    attempts,
+		WorkerId:   q.config.InstanceId,
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+		HttpCalls:  int(recorder.httpCalls.Load()),
+		CacheHits:  int(recorder.cacheHits.Load()),
+	}
+	if err != nil && runCtx.Err() != nil {
+		if cause := context.Cause(runCtx); !errors.Is(err, cause) {
+			err = fmt.Errorf("%w: %w", cause, err)
+		}
+	}
+	if err != nil {
+		message := err.Error()
+		run.Error = &message
+	}
+	defer func() {
+		q.recordJobRun(db, logger, run)
+		q.recordKindOutcome(db, logger, dbJob.Name, run.Outcome)
+	}()
+
+	if leaseLost.Load() {
+		run.Outcome = JobRunLeaseLost
+		logger.Warn("Discarding the result of a job whose lease was lost", "err", err)
+		return
+	}
+
+	if err != nil {
+		if errors.Is(err, ErrJobCancelled) {
+			run.Outcome = JobRunCancelled
+			logger.Warn("Job cancelled", "err", err)
+			if err := q.markJobFailed(db, dbJob.Id, err); err != nil {
+				if errors.Is(err, errLeaseLost) {
+					run.Outcome = JobRunLeaseLost
+				}
+				logger.Error("Error marking job as failed", "err", err)
+			}
+			return
+		}
+		if errors.Is(context.Cause(ctx), errShutdown) {
+			run.Outcome = JobRunInterrupted
+			logger.Info("Job interrupted by cancellation", "err", err)
+			if err := q.interruptJob(db, dbJob.Id); err != nil {
+				if errors.Is(err, errLeaseLost) {
+					run.Outcome = JobRunLeaseLost
+				}
+				logger.Error("Error reverting interrupted job to pending", "err", err)
+			}
+			return
+		}
+		var stop *stopError
+		if errors.As(err, &stop) {
+			run.Outcome = JobRunStopped
+			logger.Warn("Job stopped", "err", err)
+			if err := q.markJobFailed(db, dbJob.Id, err); err != nil {
+				if errors.Is(err, errLeaseLost) {
+					run.Outcome = JobRunLeaseLost
+				}
+				logger.Error("Error marking job as failed", "err", err)
+			}
+			return
+		}
+		var retryAfter *retryAfterError
+		if errors.As(err, &retryAfter) {
+			run.Outcome = JobRunDeferred
+			logger.Warn("Job deferred", "err", err, "delay", retryAfter.delay)
+			if err := q.deferJob(db, dbJob.Id, retryAfter.delay, err); err != nil {
+				if errors.Is(err, errLeaseLost) {
+					run.Outcome = JobRunLeaseLost
+				}
+				logger.Error("Error deferring job", "err", err)
+			}
+			return
+		}
+		failed, retryErr := q.retryOrFailJob(db, dbJob.Id, attempts, retryPolicyOf(provider), err)
+		run.Outcome = JobRunRetrying
+		if failed {
+			run.Outcome = JobRunFailed
+		}
+		if errors.Is(retryErr, errLeaseLost) {
+			run.Outcome = JobRunLeaseLost
+		}
+		if retryErr != nil {
+			logger.Error("Error scheduling job retry", "err", retryErr, "job-err", err)
+		} else if failed {
+			logger.Error("Job failed and ran out of attempts", "err", err, "attempt", attempts)
+		} else {
+			logger.Warn("Job failed, it will be retried", "err", err, "attempt", attempts)
+		}
+		return
+	}
+
+	run.Outcome = JobRunSucceeded
+	if info != nil {
+		run.RowsWritten = info.RowsWritten
+		if !info.Successfull {
+			run.Outcome = JobRunUnsuccessful
+		}
+	}
+
+	if err := q.finishJob(db, provider, dbJob, info); err != nil {
+		// The job was neither removed nor rescheduled, so the run did not succeed
+		run.Outcome = JobRunFailed
+		if errors.Is(err, errLeaseLost) {
+			run.Outcome = JobRunLeaseLost
+		}
+		message := fmt.Sprintf("error saving job result: %v", err)
+		run.Error = &message
+		logger.Error("Error saving job result", "err", err)
+		return
+	}
+
+	if run.Outcome == JobRunUnsuccessful {
+		logger.Warn("Job finished unsuccessfully")
+	} else {
+		logger.Info("Job finished", "duration", run.Duration())
+	}
+}
+
+func runJob(job Job, jctx JobRunContext, ctx context.Context) (info *JobFinishInformation, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return job.Run(jctx, ctx)
+}
+
+// markJobRunning starts a run of a job queued by this instance. A job that was
+// cancelled during a previous run that never finished is failed instead.
+func (q *RegisteredJobs) markJobRunning(db *sqlx.DB, id int64) (attempts int, started bool, err error) {
+	var row struct {
+		Attempts        int  `db:"attempts"`
+		CancelRequested bool `db:"cancel_requested"`
+	}
+	err = db.Get(&row, `
+	UPDATE jobs
+	SET
+		state = 'running',
+		attempts = attempts + 1,
+		locked_until = CURRENT_TIMESTAMP + make_interval(secs => $3)
+	WHERE id = $1 AND worker_id = $2 AND state = 'queued'
+	RETURNING attempts, cancel_requested
+	`, id, q.config.InstanceId, q.config.Lease.Seconds())
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if row.CancelRequested {
+		return row.Attempts, false, q.markJobFailed(db, id, ErrJobCancelled)
+	}
+	return row.Attempts, true, nil
+}
+
+// finishJob removes a job that ran successfully from the table, unless it has to
+// run again: either because it asked to be rescheduled, which is delegated to its
+// provider, or because its kind recurs, in which case the same row is reused.
+// The jobs depending on it are unblocked, or failed if it was unsuccessful.
+func (q *RegisteredJobs) finishJob(db *sqlx.DB, provider JobProvider, job DBJob, info *JobFinishInformation) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var result sql.Result
+	schedule := scheduleOf(provider)
+	if (info == nil || info.Reschedule == nil) && schedule != nil {
+		result, err = tx.Exec(`
+		UPDATE jobs
+		SET
+			state = 'pending',
+			available_at = $3,
+			attempts = 0,
+			last_error = NULL,
+			worker_id = NULL,
+			locked_until = NULL,
+			cancel_requested = FALSE
+		WHERE id = $1 AND worker_id = $2
+		`, job.Id, q.config.InstanceId, schedule.Next(time.Now()))
+	} else {
+		result, err = tx.Exec("DELETE FROM jobs WHERE id = $1 AND worker_id = $2", job.Id, q.config.InstanceId)
+	}
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected != 1 {
+		return errLeaseLost
+	}
+
+	if info == nil || info.Successfull {
+		err = resolveDependencies(tx, job.Id)
+	} else {
+		err = failDependents(tx, job.Id)
+	}
+	if err != nil {
+		return err
+	}
+
+	if info != nil && info.Reschedule != nil {
+		if err := provider.Save(tx, info.Reschedule); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
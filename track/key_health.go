@@ -0,0 +1,90 @@
+package track
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/MrNemo64/coc-tracker/util"
+)
+
+const (
+	// How often quarantined keys are checked for a finished cool-down
+	keyProbeInterval = 15 * time.Second
+	// How often the health of the keys is logged
+	keyHealthLogInterval = 10 * time.Minute
+)
+
+// checkKeys probes every key before starting, so keys the API rejects are
+// quarantined before jobs use them, and returns how many keys are healthy.
+// Keys that could not be probed, for example because the API is down, are
+// given the benefit of the doubt.
+func (c *CocClient) checkKeys() int {
+	for _, key := range c.keys.All() {
+		c.probeKey(key)
+	}
+	healthy := c.keys.Healthy()
+	c.logger.Info("Checked keys", "keys", c.keys.Len(), "healthy", healthy)
+	return healthy
+}
+
+// probeQuarantinedKeys probes the quarantined keys once their cool-down ends,
+// putting them back in use if the API accepts them again, and periodically
+// logs the health of every key.
+func (c *CocClient) probeQuarantinedKeys() {
+	probeTicker := time.NewTicker(keyProbeInterval)
+	defer probeTicker.Stop()
+	logTicker := time.NewTicker(keyHealthLogInterval)
+	defer logTicker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-logTicker.C:
+			c.logKeyHealth()
+		case <-probeTicker.C:
+			for _, key := range c.keys.DueForProbe() {
+				c.probeKey(key)
+			}
+		}
+	}
+}
+
+// probeKey requests a cheap endpoint with a specific key, its outcome updates
+// the health of the key like any other request.
+func (c *CocClient) probeKey(key *apiKey) {
+	ctx, cancel := context.WithTimeout(c.ctx, 10*time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, util.BaseUrl+util.GoldpassEndpoint, nil)
+	if err != nil {
+		return
+	}
+	// The key may have been removed since it was found due for a probe
+	if !c.keys.Reserve(key) {
+		return
+	}
+	response, err := c.sendWith(request, key)
+	if err != nil {
+		c.logger.Warn("Error probing API key", "key", key.Name(), "err", err)
+		return
+	}
+	response.Body.Close()
+}
+
+func (c *CocClient) logKeyHealth() {
+	for _, health := range c.keys.Health() {
+		c.logger.Info("API key health", "key", health.Name, "source", health.Source, "state", health.State,
+			"rate", health.Rate, "successes", health.Successes, "throttles", health.Throttles,
+			"access-denied", health.AccessDenied, "invalid-ip", health.InvalidIp, "latency-ms", health.LatencyMs)
+	}
+}
+
+type keysReport struct {
+	Healthy int         `json:"healthy"`
+	Keys    []KeyHealth `json:"keys"`
+}
+
+// KeysHealth reports the health of the keys to the admin API.
+func (c *CocClient) KeysHealth() any {
+	return keysReport{Healthy: c.keys.Healthy(), Keys: c.keys.Health()}
+}
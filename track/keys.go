@@ -1,80 +1,608 @@
 package track
 
 import (
-	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
+	"path"
+	"slices"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/MrNemo64/coc-tracker/coc"
+	"github.com/MrNemo64/coc-tracker/track/jobs"
 	"golang.org/x/time/rate"
 )
 
+var ErrNoKeys = errors.New("no api keys available")
+
+// KeySchedulerConfiguration sets the rate every key starts with and the bounds
+// it adapts within. Keys speed up a little with every successful request and
+// halve their rate when the API throttles them, so each one settles close to
+// the real limit the API enforces on it.
+type KeySchedulerConfiguration struct {
+	// Requests per second a key starts with
+	Rate float64
+	// Highest rate a key is raised to while the API doesn't throttle it
+	MaxRate float64
+	// Lowest rate a key is lowered to when throttled
+	MinRate float64
+	// Requests a key can make at once after being idle
+	Burst int
+	// Requests per second added to the rate of a key on every success
+	RateIncrease float64
+	// Requests in a row the API has to deny a key before it is quarantined
+	QuarantineAfter int
+	// How long a quarantined key is left alone before probing it, doubled
+	// every time the probe fails
+	QuarantineCooldown    time.Duration
+	MaxQuarantineCooldown time.Duration
+}
+
+func DefaultKeySchedulerConfiguration() KeySchedulerConfiguration {
+	return KeySchedulerConfiguration{
+		Rate:         1,
+		MaxRate:      40,
+		MinRate:      0.1,
+		Burst:        35,
+		RateIncrease: 0.1,
+
+		QuarantineAfter:       5,
+		QuarantineCooldown:    time.Minute,
+		MaxQuarantineCooldown: 30 * time.Minute,
+	}
+}
+
+func KeySchedulerConfigurationFromEnv() KeySchedulerConfiguration {
+	conf := DefaultKeySchedulerConfiguration()
+	for env, field := range map[string]*float64{
+		"KEY_RATE":          &conf.Rate,
+		"KEY_MAX_RATE":      &conf.MaxRate,
+		"KEY_MIN_RATE":      &conf.MinRate,
+		"KEY_RATE_INCREASE": &conf.RateIncrease,
+	} {
+		if value := os.Getenv(env); value != "" {
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				panic(err)
+			}
+			if n <= 0 {
+				panic(fmt.Errorf("%s must be positive, got %v", env, n))
+			}
+			*field = n
+		}
+	}
+	if burst := os.Getenv("KEY_BURST"); burst != "" {
+		n, err := strconv.Atoi(burst)
+		if err != nil {
+			panic(err)
+		}
+		if n < 1 {
+			panic(fmt.Errorf("KEY_BURST must be at least 1, got %d", n))
+		}
+		conf.Burst = n
+	}
+	if after := os.Getenv("KEY_QUARANTINE_AFTER"); after != "" {
+		n, err := strconv.Atoi(after)
+		if err != nil {
+			panic(err)
+		}
+		if n < 1 {
+			panic(fmt.Errorf("KEY_QUARANTINE_AFTER must be at least 1, got %d", n))
+		}
+		conf.QuarantineAfter = n
+	}
+	for env, field := range map[string]*time.Duration{
+		"KEY_QUARANTINE_COOLDOWN":     &conf.QuarantineCooldown,
+		"KEY_MAX_QUARANTINE_COOLDOWN": &conf.MaxQuarantineCooldown,
+	} {
+		if value := os.Getenv(env); value != "" {
+			duration, err := time.ParseDuration(value)
+			if err != nil {
+				panic(fmt.Errorf("invalid duration in %s: %w", env, err))
+			}
+			if duration <= 0 {
+				panic(fmt.Errorf("%s must be positive, got %s", env, duration))
+			}
+			*field = duration
+		}
+	}
+	if conf.QuarantineCooldown > conf.MaxQuarantineCooldown {
+		panic(fmt.Errorf("KEY_QUARANTINE_COOLDOWN (%s) must not be longer than KEY_MAX_QUARANTINE_COOLDOWN (%s)", conf.QuarantineCooldown, conf.MaxQuarantineCooldown))
+	}
+	if conf.Rate < conf.MinRate || conf.Rate > conf.MaxRate {
+		panic(fmt.Errorf("KEY_RATE must be between KEY_MIN_RATE and KEY_MAX_RATE, got %v not in [%v, %v]", conf.Rate, conf.MinRate, conf.MaxRate))
+	}
+	return conf
+}
+
+// Weight of the last request in the average latency of a key
+const latencySmoothing = 0.2
+
 type apiKey struct {
-	key       string
+	key string
+	// Where the key was loaded from, reloading a source only replaces its keys
+	source    string
+	spec      KeySpec
 	limiter   *rate.Limiter
+	maxRate   rate.Limit
+	minRate   rate.Limit
+	increase  rate.Limit
 	timesUsed int
+	inFlight  int
+	successes int
+	failures  int
+	throttles int
+	// Requests the API denied because of the key
+	accessDenied int
+	// Requests the API denied because the key doesn't allow this IP
+	invalidIp int
+	// Moving average of how long the requests made with the key take
+	latency time.Duration
+	// The key is only used if no other is available until then
+	throttledUntil time.Time
+
+	quarantineAfter    int
+	baseCooldown       time.Duration
+	maxCooldown        time.Duration
+	consecutiveDenials int
+	quarantines        int
+	// Quarantined keys are never handed out, they are probed once the
+	// cool-down ends and used again if the probe works
+	quarantined      bool
+	quarantinedUntil time.Time
+	cooldown         time.Duration
+	// Set once the key is removed from the list, closed when the requests it
+	// still had in flight finish
+	drained chan struct{}
+}
+
+func newAPIKey(key string, source string, conf KeySchedulerConfiguration) *apiKey {
+	return &apiKey{
+		key:      key,
+		source:   source,
+		limiter:  rate.NewLimiter(rate.Limit(conf.Rate), conf.Burst),
+		maxRate:  rate.Limit(conf.MaxRate),
+		minRate:  rate.Limit(conf.MinRate),
+		increase: rate.Limit(conf.RateIncrease),
+
+		quarantineAfter: conf.QuarantineAfter,
+		baseCooldown:    conf.QuarantineCooldown,
+		maxCooldown:     conf.MaxQuarantineCooldown,
+	}
+}
+
+// Name identifies the key in logs without giving it away.
+func (k *apiKey) Name() string {
+	suffix := "..."
+	if len(k.key) > 6 {
+		suffix += k.key[len(k.key)-6:]
+	}
+	if k.spec.Label != "" {
+		return k.spec.Label + " (" + suffix + ")"
+	}
+	return suffix
+}
+
+// serves reports whether the key can be used for a request made by a job of
+// the given kind. Requests not made by jobs can use any key.
+func (k *apiKey) serves(jobName string) bool {
+	if len(k.spec.Kinds) == 0 || jobName == "" {
+		return true
+	}
+	for _, pattern := range k.spec.Kinds {
+		if matched, _ := path.Match(pattern, jobName); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// update applies a new spec to a loaded key, keeping its limiter unless the
+// spec changes the rate or burst of the key.
+func (k *apiKey) update(spec KeySpec, conf KeySchedulerConfiguration) {
+	if spec.Rate != k.spec.Rate || spec.Burst != k.spec.Burst {
+		conf = spec.configure(conf)
+		k.limiter.SetLimit(rate.Limit(conf.Rate))
+		k.limiter.SetBurst(conf.Burst)
+		k.maxRate = rate.Limit(conf.MaxRate)
+		k.minRate = rate.Limit(conf.MinRate)
+	}
+	k.spec = spec
+}
+
+// cost estimates how long a new request made with the key would take to finish:
+// the wait for a token plus the time needed by the requests already in flight.
+func (k *apiKey) cost(now time.Time) time.Duration {
+	var wait time.Duration
+	if tokens := k.limiter.TokensAt(now); tokens < 1 && k.limiter.Limit() != rate.Inf {
+		wait = time.Duration((1 - tokens) / float64(k.limiter.Limit()) * float64(time.Second))
+	}
+	return wait + time.Duration(k.inFlight)*k.latency
+}
+
+// better reports whether a new request should rather use k than other.
+func (k *apiKey) better(other *apiKey, now time.Time) bool {
+	if cost, otherCost := k.cost(now), other.cost(now); cost != otherCost {
+		return cost < otherCost
+	}
+	if k.inFlight != other.inFlight {
+		return k.inFlight < other.inFlight
+	}
+	return k.timesUsed < other.timesUsed
+}
+
+// adapt raises the rate of the key after a success and halves it after the API
+// throttled it, staying within the configured bounds.
+func (k *apiKey) adapt(now time.Time, throttled bool) {
+	limit := k.limiter.Limit()
+	if limit == rate.Inf || k.maxRate == 0 {
+		return
+	}
+	if throttled {
+		limit = max(limit/2, k.minRate)
+	} else {
+		limit = min(limit+k.increase, k.maxRate)
+	}
+	k.limiter.SetLimitAt(now, limit)
+}
+
+// quarantine stops handing out the key until it passes a probe. Quarantining a
+// key that already is doubles its cool-down.
+func (k *apiKey) quarantine(now time.Time) {
+	if k.quarantined {
+		k.cooldown = min(k.cooldown*2, k.maxCooldown)
+	} else {
+		k.quarantined = true
+		k.quarantines++
+		k.cooldown = k.baseCooldown
+	}
+	k.quarantinedUntil = now.Add(k.cooldown)
+}
+
+// Health states of a key
+const (
+	KeyHealthy     = "healthy"
+	KeyThrottled   = "throttled"
+	KeyQuarantined = "quarantined"
+)
+
+func (k *apiKey) state(now time.Time) string {
+	switch {
+	case k.quarantined:
+		return KeyQuarantined
+	case k.throttledUntil.After(now):
+		return KeyThrottled
+	}
+	return KeyHealthy
+}
+
+// KeyHealth is a snapshot of how a key is doing.
+type KeyHealth struct {
+	Name         string   `json:"name"`
+	Label        string   `json:"label,omitempty"`
+	Source       string   `json:"source"`
+	Kinds        []string `json:"kinds,omitempty"`
+	State        string   `json:"state"`
+	Rate         float64  `json:"rate"`
+	InFlight     int      `json:"in_flight"`
+	Successes    int      `json:"successes"`
+	Failures     int      `json:"failures"`
+	Throttles    int      `json:"throttles"`
+	AccessDenied int      `json:"access_denied"`
+	InvalidIp    int      `json:"invalid_ip"`
+	Quarantines  int      `json:"quarantines"`
+	LatencyMs    float64  `json:"latency_ms"`
+	// Set while throttled or quarantined
+	Until *time.Time `json:"until,omitempty"`
+}
+
+func (k *apiKey) health(now time.Time) KeyHealth {
+	health := KeyHealth{
+		Name:         k.Name(),
+		Label:        k.spec.Label,
+		Source:       k.source,
+		Kinds:        k.spec.Kinds,
+		State:        k.state(now),
+		Rate:         float64(k.limiter.Limit()),
+		InFlight:     k.inFlight,
+		Successes:    k.successes,
+		Failures:     k.failures,
+		Throttles:    k.throttles,
+		AccessDenied: k.accessDenied,
+		InvalidIp:    k.invalidIp,
+		Quarantines:  k.quarantines,
+		LatencyMs:    float64(k.latency) / float64(time.Millisecond),
+	}
+	switch health.State {
+	case KeyQuarantined:
+		until := k.quarantinedUntil
+		health.Until = &until
+	case KeyThrottled:
+		until := k.throttledUntil
+		health.Until = &until
+	}
+	return health
 }
 
 type KeyList struct {
-	keys []apiKey
+	keys []*apiKey
 	mu   sync.Mutex
+	// Logs changes in the health of the keys if set
+	logger *slog.Logger
 }
 
-func (kl *KeyList) GetKey() string {
+// Acquire reserves a token on the key expected to serve a new request the
+// soonest, counting the wait for a token and the requests it already has in
+// flight, and waits until the token can be used. Throttled keys are only used
+// when all the keys are throttled, waiting for the first one to recover. Every
+// key returned by Acquire must be given back with Release.
+func (kl *KeyList) Acquire(ctx context.Context) (*apiKey, error) {
+	jobName, _ := jobs.JobNameFromContext(ctx)
+	kl.mu.Lock()
+	now := time.Now()
+	var bestKey *apiKey
+	for _, key := range kl.keys {
+		if key.quarantined || key.throttledUntil.After(now) || !key.serves(jobName) {
+			continue
+		}
+		if bestKey == nil || key.better(bestKey, now) {
+			bestKey = key
+		}
+	}
+	if bestKey == nil {
+		for _, key := range kl.keys {
+			if !key.quarantined && key.serves(jobName) && (bestKey == nil || key.throttledUntil.Before(bestKey.throttledUntil)) {
+				bestKey = key
+			}
+		}
+	}
+	if bestKey == nil {
+		kl.mu.Unlock()
+		return nil, ErrNoKeys
+	}
+
+	reservation := bestKey.limiter.ReserveN(now, 1)
+	bestKey.inFlight++
+	bestKey.timesUsed++
+	throttledUntil := bestKey.throttledUntil
+	kl.mu.Unlock()
+
+	delay := max(reservation.DelayFrom(now), throttledUntil.Sub(now))
+	if delay <= 0 {
+		return bestKey, nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return bestKey, nil
+	case <-ctx.Done():
+		reservation.Cancel()
+		kl.mu.Lock()
+		bestKey.timesUsed--
+		kl.finish(bestKey)
+		kl.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// Release records the outcome of a request made with a key obtained from
+// Acquire and how long it took, adapting the rate of the key to it. err is
+// either the error sending the request or the *coc.APIError of the response.
+// Keys are quarantined right away if the API doesn't allow them from this IP,
+// and after being denied QuarantineAfter times in a row otherwise.
+func (kl *KeyList) Release(key *apiKey, statusCode int, latency time.Duration, err error) {
 	kl.mu.Lock()
 	defer kl.mu.Unlock()
 
-	var bestKey *apiKey
+	kl.finish(key)
+	if latency > 0 {
+		if key.latency == 0 {
+			key.latency = latency
+		} else {
+			key.latency = time.Duration(latencySmoothing*float64(latency) + (1-latencySmoothing)*float64(key.latency))
+		}
+	}
 
-	// Find the key with the most available tokens
-	for i := range kl.keys {
-		if bestKey == nil || (kl.keys[i].limiter.Allow() && kl.keys[i].limiter.Burst() > bestKey.limiter.Burst()) {
-			bestKey = &kl.keys[i]
+	now := time.Now()
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		key.failures++
+		key.throttles++
+		key.adapt(now, true)
+	case errors.Is(err, coc.ErrInvalidIp):
+		key.failures++
+		key.invalidIp++
+		kl.quarantine(key, now, "key not allowed from this ip")
+	case statusCode == http.StatusForbidden:
+		key.failures++
+		key.accessDenied++
+		key.consecutiveDenials++
+		if key.quarantined || key.consecutiveDenials >= key.quarantineAfter {
+			kl.quarantine(key, now, "access denied")
 		}
+	case statusCode == 0 && err != nil:
+		// The request never reached the API, which says nothing about the key
+		key.failures++
+	case statusCode >= 200 && statusCode < 300 || statusCode == http.StatusNotModified:
+		key.successes++
+		key.consecutiveDenials = 0
+		if key.quarantined {
+			key.quarantined = false
+			if kl.logger != nil {
+				kl.logger.Info("API key recovered from quarantine", "key", key.Name())
+			}
+		}
+		key.adapt(now, false)
 	}
+	// Other errors, like a missing resource or the API being down, say nothing
+	// about the key either
+}
 
-	// If a key was found with available tokens, consume one and return the key
-	if bestKey != nil {
-		bestKey.timesUsed++
-		return bestKey.key
+// quarantine must be called with the lock held.
+func (kl *KeyList) quarantine(key *apiKey, now time.Time, reason string) {
+	key.quarantine(now)
+	if kl.logger != nil {
+		kl.logger.Warn("API key quarantined", "key", key.Name(), "reason", reason, "cooldown", key.cooldown, "healthy-keys", kl.healthy(now))
 	}
+}
 
-	// If no key has available tokens, block until one becomes available
-	for {
-		for i := range kl.keys {
-			reserve := kl.keys[i].limiter.Reserve()
-			if reserve.OK() {
-				time.Sleep(reserve.Delay())
-				kl.keys[i].timesUsed++
-				return kl.keys[i].key
-			}
+// healthy counts the keys that are not quarantined. Must be called with the
+// lock held.
+func (kl *KeyList) healthy(now time.Time) int {
+	count := 0
+	for _, key := range kl.keys {
+		if key.state(now) != KeyQuarantined {
+			count++
+		}
+	}
+	return count
+}
+
+// Healthy returns how many keys are not quarantined.
+func (kl *KeyList) Healthy() int {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	return kl.healthy(time.Now())
+}
+
+// Health returns a snapshot of the health of every key.
+func (kl *KeyList) Health() []KeyHealth {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	now := time.Now()
+	health := make([]KeyHealth, 0, len(kl.keys))
+	for _, key := range kl.keys {
+		health = append(health, key.health(now))
+	}
+	return health
+}
+
+// Reserve marks a request made with a specific key, bypassing the scheduler and
+// quarantine, for probes. Returns false if the key was removed from the list,
+// otherwise the key must be given back with Release.
+func (kl *KeyList) Reserve(key *apiKey) bool {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	if !slices.Contains(kl.keys, key) {
+		return false
+	}
+	key.inFlight++
+	key.timesUsed++
+	return true
+}
+
+// DueForProbe returns the quarantined keys whose cool-down is over.
+func (kl *KeyList) DueForProbe() []*apiKey {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	now := time.Now()
+	var due []*apiKey
+	for _, key := range kl.keys {
+		if key.quarantined && !key.quarantinedUntil.After(now) {
+			due = append(due, key)
 		}
-		time.Sleep(10 * time.Millisecond) // Add a small sleep to prevent tight loop
 	}
+	return due
+}
+
+// All returns every key in the list, for probing them.
+func (kl *KeyList) All() []*apiKey {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	return slices.Clone(kl.keys)
 }
-func LoadKeysFromFile(path string) (*KeyList, error) {
-	readFile, err := os.Open(path)
 
-	if err != nil {
-		return nil, err
+// finish marks a request of the key as done. Must be called with the lock held.
+func (kl *KeyList) finish(key *apiKey) {
+	key.inFlight--
+	if key.inFlight > 0 || key.drained == nil {
+		return
+	}
+	select {
+	case <-key.drained:
+	default:
+		close(key.drained)
 	}
+}
 
-	defer readFile.Close()
+// Replace makes the given keys the keys of a source, keeping the state of the
+// keys that were already loaded so their rates don't start over. Disabled keys
+// and tokens already loaded by another source are skipped. Removed keys stop
+// being handed out right away, wait for their requests with Drain.
+func (kl *KeyList) Replace(source string, specs []KeySpec, conf KeySchedulerConfiguration) (added []string, removed []*apiKey) {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
 
-	fileScanner := bufio.NewScanner(readFile)
-	fileScanner.Split(bufio.ScanLines)
+	wanted := make(map[string]KeySpec, len(specs))
+	for _, spec := range specs {
+		if spec.Enabled {
+			wanted[spec.Token] = spec
+		}
+	}
 
-	keyList := &KeyList{
-		keys: make([]apiKey, 0),
+	keys := make([]*apiKey, 0, len(kl.keys)+len(specs))
+	loaded := make(map[string]bool, len(kl.keys))
+	for _, key := range kl.keys {
+		if key.source == source {
+			spec, ok := wanted[key.key]
+			if !ok {
+				key.drained = make(chan struct{})
+				if key.inFlight == 0 {
+					close(key.drained)
+				}
+				removed = append(removed, key)
+				continue
+			}
+			key.update(spec, conf)
+		}
+		keys = append(keys, key)
+		loaded[key.key] = true
 	}
+	for _, spec := range specs {
+		if !spec.Enabled || loaded[spec.Token] {
+			continue
+		}
+		key := newAPIKey(spec.Token, source, spec.configure(conf))
+		key.spec = spec
+		keys = append(keys, key)
+		loaded[spec.Token] = true
+		added = append(added, spec.Token)
+	}
+	kl.keys = keys
+	return added, removed
+}
 
-	for fileScanner.Scan() {
-		keyList.keys = append(keyList.keys, apiKey{
-			key:       fileScanner.Text(),
-			limiter:   rate.NewLimiter(rate.Every(1*time.Second), 35),
-			timesUsed: 0,
-		})
+// Drain waits until the requests still in flight on removed keys finish.
+func (kl *KeyList) Drain(ctx context.Context, removed []*apiKey) error {
+	for _, key := range removed {
+		select {
+		case <-key.drained:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
+	return nil
+}
+
+// Len returns how many keys are loaded, including quarantined ones.
+func (kl *KeyList) Len() int {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	return len(kl.keys)
+}
 
-	return keyList, nil
+// Throttle avoids using a key for a while after the API throttled it.
+func (kl *KeyList) Throttle(key *apiKey, backoff time.Duration) {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	if until := time.Now().Add(backoff); until.After(key.throttledUntil) {
+		key.throttledUntil = until
+	}
 }
@@ -0,0 +1,243 @@
+package track
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Source of the keys read from KEYS_FILE
+const keysFileSource = "file"
+
+// KeySpec describes a key to load and how to use it.
+type KeySpec struct {
+	Token string
+	// Shown in logs and the admin API instead of the end of the token
+	Label string
+	// Replaces KEY_RATE and KEY_MAX_RATE for the key if set
+	Rate float64
+	// Replaces KEY_BURST for the key if set
+	Burst int
+	// Patterns of the job kinds the key is used for, like the ones of the pause
+	// command. Keys without patterns are used for every job.
+	Kinds []string
+	// Disabled keys stay in the file but are not used
+	Enabled bool
+}
+
+// configure applies the overrides of the key to the configuration.
+func (s KeySpec) configure(conf KeySchedulerConfiguration) KeySchedulerConfiguration {
+	if s.Rate > 0 {
+		conf.Rate = s.Rate
+		conf.MaxRate = s.Rate
+		conf.MinRate = min(conf.MinRate, s.Rate)
+	}
+	if s.Burst > 0 {
+		conf.Burst = s.Burst
+	}
+	return conf
+}
+
+// tokenSpecs makes enabled keys without overrides out of tokens.
+func tokenSpecs(tokens ...string) []KeySpec {
+	specs := make([]KeySpec, 0, len(tokens))
+	for _, token := range tokens {
+		specs = append(specs, KeySpec{Token: token, Enabled: true})
+	}
+	return specs
+}
+
+// KeysFileError points at the line of the keys file with a problem.
+type KeysFileError struct {
+	Path    string
+	Line    int
+	Message string
+}
+
+func (e *KeysFileError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Message)
+}
+
+// readKeysFile reads the keys in a file. Files ending in .yaml, .yml or .json
+// list the keys with their settings:
+//
+//	keys:
+//	  - token: eyJ0eXAiOiJKV1Qi...
+//	    label: main
+//	    rate: 10
+//	    burst: 20
+//	    kinds: ["update/*"]
+//	    enabled: true
+//
+// Any other file has a token per line, blank lines and lines starting with #
+// are skipped. Every problem found is returned, each with its line.
+func readKeysFile(file string) ([]KeySpec, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml", ".json":
+		return parseStructuredKeys(file, content)
+	}
+	return parsePlainKeys(file, content)
+}
+
+func parsePlainKeys(file string, content []byte) ([]KeySpec, error) {
+	var specs []KeySpec
+	var errs []error
+	seen := make(map[string]int)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	line := 0
+	for scanner.Scan() {
+		line++
+		token := strings.TrimSpace(scanner.Text())
+		if token == "" || strings.HasPrefix(token, "#") {
+			continue
+		}
+		if strings.ContainsFunc(token, isSpace) {
+			errs = append(errs, &KeysFileError{file, line, "key has whitespace in it"})
+			continue
+		}
+		if previous, ok := seen[token]; ok {
+			errs = append(errs, &KeysFileError{file, line, fmt.Sprintf("key already listed on line %d", previous)})
+			continue
+		}
+		seen[token] = line
+		specs = append(specs, KeySpec{Token: token, Enabled: true})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return specs, nil
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\r' || r == '\n' || r == '\v' || r == '\f'
+}
+
+// keyEntry is a key as written in a structured keys file.
+type keyEntry struct {
+	Token   string   `yaml:"token"`
+	Label   string   `yaml:"label"`
+	Rate    float64  `yaml:"rate"`
+	Burst   int      `yaml:"burst"`
+	Kinds   []string `yaml:"kinds"`
+	Enabled *bool    `yaml:"enabled"`
+}
+
+var keyEntryFields = []string{"token", "label", "rate", "burst", "kinds", "enabled"}
+
+func parseStructuredKeys(file string, content []byte) ([]KeySpec, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if len(document.Content) == 0 {
+		return nil, nil
+	}
+
+	// The keys can be listed at the top or under a keys field
+	list := document.Content[0]
+	if list.Kind == yaml.MappingNode {
+		root := list
+		list = nil
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			if field := root.Content[i]; field.Value != "keys" {
+				return nil, &KeysFileError{file, field.Line, fmt.Sprintf("unknown field %q, expected keys", field.Value)}
+			}
+			list = root.Content[i+1]
+		}
+		if list == nil {
+			return nil, &KeysFileError{file, root.Line, "missing keys field"}
+		}
+	}
+	if list.Kind != yaml.SequenceNode {
+		return nil, &KeysFileError{file, list.Line, "keys must be a list"}
+	}
+
+	var specs []KeySpec
+	var errs []error
+	seen := make(map[string]int)
+	for _, node := range list.Content {
+		spec, err := parseKeyEntry(file, node)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if previous, ok := seen[spec.Token]; ok {
+			errs = append(errs, &KeysFileError{file, node.Line, fmt.Sprintf("key already listed on line %d", previous)})
+			continue
+		}
+		seen[spec.Token] = node.Line
+		specs = append(specs, spec)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return specs, nil
+}
+
+// parseKeyEntry reads a key of the list, either just its token or a mapping
+// with its settings.
+func parseKeyEntry(file string, node *yaml.Node) (KeySpec, error) {
+	fail := func(line int, format string, args ...any) (KeySpec, error) {
+		return KeySpec{}, &KeysFileError{file, line, fmt.Sprintf(format, args...)}
+	}
+
+	var entry keyEntry
+	switch node.Kind {
+	case yaml.ScalarNode:
+		entry.Token = node.Value
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if field := node.Content[i]; !slices.Contains(keyEntryFields, field.Value) {
+				return fail(field.Line, "unknown field %q, expected one of %s", field.Value, strings.Join(keyEntryFields, ", "))
+			}
+		}
+		if err := node.Decode(&entry); err != nil {
+			return fail(node.Line, "invalid key: %v", err)
+		}
+	default:
+		return fail(node.Line, "a key must be a token or a mapping")
+	}
+
+	token := strings.TrimSpace(entry.Token)
+	switch {
+	case token == "":
+		return fail(node.Line, "key has no token")
+	case strings.ContainsFunc(token, isSpace):
+		return fail(node.Line, "key has whitespace in it")
+	case entry.Rate < 0:
+		return fail(node.Line, "rate must be positive, got %v", entry.Rate)
+	case entry.Burst < 0:
+		return fail(node.Line, "burst must be positive, got %d", entry.Burst)
+	}
+	for _, pattern := range entry.Kinds {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fail(node.Line, "invalid job kind pattern %q", pattern)
+		}
+	}
+
+	return KeySpec{
+		Token:   token,
+		Label:   entry.Label,
+		Rate:    entry.Rate,
+		Burst:   entry.Burst,
+		Kinds:   entry.Kinds,
+		Enabled: entry.Enabled == nil || *entry.Enabled,
+	}, nil
+}
@@ -0,0 +1,100 @@
+package track
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeKeysFile(t *testing.T, name, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatalf("Could not write keys file: %v", err)
+	}
+	return file
+}
+
+func TestReadKeysFile(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Plain", func(t *testing.T) {
+		t.Parallel()
+		file := writeKeysFile(t, ".keys", "first  \n\n# comment\n\tsecond\r\n")
+		specs, err := readKeysFile(file)
+		assert.NoError(t, err)
+		assert.Equal(t, tokenSpecs("first", "second"), specs)
+
+		file = writeKeysFile(t, ".keys", "first\ntwo words\nfirst\n")
+		_, err = readKeysFile(file)
+		assert.ErrorContains(t, err, file+":2: key has whitespace in it")
+		assert.ErrorContains(t, err, file+":3: key already listed on line 1")
+	})
+
+	t.Run("YAML", func(t *testing.T) {
+		t.Parallel()
+		file := writeKeysFile(t, "keys.yaml", `
+keys:
+  - token: first
+    label: main
+    rate: 10
+    burst: 20
+    kinds: ["update/*"]
+  - token: second
+    enabled: false
+  - third
+`)
+		specs, err := readKeysFile(file)
+		assert.NoError(t, err)
+		assert.Equal(t, []KeySpec{
+			{Token: "first", Label: "main", Rate: 10, Burst: 20, Kinds: []string{"update/*"}, Enabled: true},
+			{Token: "second"},
+			{Token: "third", Enabled: true},
+		}, specs)
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		t.Parallel()
+		file := writeKeysFile(t, "keys.json", `[{"token": "first", "label": "main"}, {"token": "second", "enabled": false}]`)
+		specs, err := readKeysFile(file)
+		assert.NoError(t, err)
+		assert.Equal(t, []KeySpec{{Token: "first", Label: "main", Enabled: true}, {Token: "second"}}, specs)
+	})
+
+	t.Run("Points at invalid keys", func(t *testing.T) {
+		t.Parallel()
+		file := writeKeysFile(t, "keys.yml", `keys:
+  - token: first
+    rate: -1
+  - label: no token
+  - token: second
+    kind: ["update/*"]
+  - token: third
+    kinds: ["[update"]
+  - token: first
+`)
+		_, err := readKeysFile(file)
+		assert.ErrorContains(t, err, file+":2: rate must be positive")
+		assert.ErrorContains(t, err, file+":4: key has no token")
+		assert.ErrorContains(t, err, file+`:6: unknown field "kind"`)
+		assert.ErrorContains(t, err, file+`:7: invalid job kind pattern "[update"`)
+		assert.NotContains(t, err.Error(), "already listed", "Invalid keys are not counted as listed")
+
+		file = writeKeysFile(t, "keys.yaml", "keys:\n  - token: first\n  - token: first\n")
+		_, err = readKeysFile(file)
+		assert.ErrorContains(t, err, file+":3: key already listed on line 2")
+
+		file = writeKeysFile(t, "keys.yaml", "keys:\n  - token: first\n\t- token: second\n")
+		_, err = readKeysFile(file)
+		assert.ErrorContains(t, err, file+": yaml: line", "Syntax errors have the line given by the parser")
+
+		var fileErr *KeysFileError
+		file = writeKeysFile(t, "keys.yaml", "tokens: []\n")
+		_, err = readKeysFile(file)
+		if assert.ErrorAs(t, err, &fileErr) {
+			assert.Equal(t, 1, fileErr.Line)
+		}
+	})
+}
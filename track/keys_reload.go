@@ -0,0 +1,92 @@
+package track
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// How often the keys file is checked for changes
+const keysFilePollInterval = 10 * time.Second
+
+// How long requests still using a removed key are waited for before giving up
+// on logging their end
+const keyDrainTimeout = time.Minute
+
+// watchKeysFile reloads the keys file whenever it is modified or the process
+// receives SIGHUP, until the client stops.
+func (c *CocClient) watchKeysFile(logger *slog.Logger) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	ticker := time.NewTicker(keysFilePollInterval)
+	defer ticker.Stop()
+
+	modTime := keysFileModTime(c.keysFile)
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-hangup:
+			logger.Info("Reloading keys file on SIGHUP")
+		case <-ticker.C:
+			current := keysFileModTime(c.keysFile)
+			if current.Equal(modTime) {
+				continue
+			}
+			logger.Info("Keys file changed, reloading it")
+		}
+		modTime = keysFileModTime(c.keysFile)
+		c.reloadKeysFile(logger)
+	}
+}
+
+func keysFileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// reloadKeysFile swaps the keys of the file for its current content. A file
+// that can't be read or would leave the tracker without keys is ignored.
+func (c *CocClient) reloadKeysFile(logger *slog.Logger) {
+	specs, err := readKeysFile(c.keysFile)
+	if err != nil {
+		logger.Error("Error reading keys file, keeping the loaded keys", "err", err)
+		return
+	}
+	enabled := 0
+	for _, spec := range specs {
+		if spec.Enabled {
+			enabled++
+		}
+	}
+	if enabled == 0 && c.keyPortal == nil {
+		logger.Error("Keys file has no enabled keys, keeping the loaded keys")
+		return
+	}
+
+	added, removed := c.keys.Replace(keysFileSource, specs, c.keyConf)
+	logger.Info("Reloaded keys file", "added", len(added), "removed", len(removed), "keys", c.keys.Len())
+	if len(removed) == 0 {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(c.ctx, keyDrainTimeout)
+		defer cancel()
+		if err := c.keys.Drain(ctx, removed); err != nil {
+			logger.Warn("Removed keys still had requests in flight", "err", err)
+			return
+		}
+		for _, key := range removed {
+			logger.Info("Removed key drained", "key", key.Name())
+		}
+	}()
+}
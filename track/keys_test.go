@@ -0,0 +1,391 @@
+package track
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MrNemo64/coc-tracker/coc"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+func makeTestKeyList(names ...string) *KeyList {
+	keys := &KeyList{}
+	for _, name := range names {
+		keys.keys = append(keys.keys, newAPIKey(name, keysFileSource, KeySchedulerConfiguration{
+			Rate:         1,
+			MaxRate:      1,
+			MinRate:      1,
+			Burst:        2,
+			RateIncrease: 1,
+
+			QuarantineAfter:       2,
+			QuarantineCooldown:    50 * time.Millisecond,
+			MaxQuarantineCooldown: 100 * time.Millisecond,
+		}))
+	}
+	return keys
+}
+
+func TestKeyListAcquire(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Spreads requests across keys", func(t *testing.T) {
+		t.Parallel()
+		keys := makeTestKeyList("a", "b")
+
+		used := make(map[string]int)
+		for i := 0; i < 4; i++ {
+			key, err := keys.Acquire(context.Background())
+			if err != nil {
+				t.Fatalf("Could not acquire key: %v", err)
+			}
+			used[key.key]++
+			keys.Release(key, 200, 0, nil)
+		}
+
+		assert.Equal(t, map[string]int{"a": 2, "b": 2}, used)
+	})
+
+	t.Run("Gives up when the context is cancelled", func(t *testing.T) {
+		t.Parallel()
+		keys := makeTestKeyList("a")
+		keys.keys[0].limiter = rate.NewLimiter(rate.Every(time.Hour), 1)
+
+		key, err := keys.Acquire(context.Background())
+		if err != nil {
+			t.Fatalf("Could not acquire key: %v", err)
+		}
+		keys.Release(key, 200, 0, nil)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err = keys.Acquire(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 0, keys.keys[0].inFlight)
+		assert.Equal(t, 1, keys.keys[0].timesUsed)
+	})
+
+	t.Run("Fails without keys", func(t *testing.T) {
+		t.Parallel()
+		_, err := makeTestKeyList().Acquire(context.Background())
+		assert.ErrorIs(t, err, ErrNoKeys)
+	})
+}
+
+func TestKeyListRelease(t *testing.T) {
+	t.Parallel()
+	keys := makeTestKeyList("a")
+	keys.keys[0].limiter = rate.NewLimiter(rate.Inf, 1)
+
+	for _, outcome := range []struct {
+		status int
+		err    error
+	}{{200, nil}, {404, nil}, {429, nil}, {403, nil}, {0, errors.New("connection reset")}} {
+		key, err := keys.Acquire(context.Background())
+		if err != nil {
+			t.Fatalf("Could not acquire key: %v", err)
+		}
+		keys.Release(key, outcome.status, 0, outcome.err)
+	}
+
+	assert.Equal(t, 0, keys.keys[0].inFlight)
+	assert.Equal(t, 1, keys.keys[0].successes, "Only successful responses count as successes")
+	assert.Equal(t, 3, keys.keys[0].failures)
+}
+
+func TestKeyListSkipsQuarantinedAndThrottledKeys(t *testing.T) {
+	t.Parallel()
+	keys := makeTestKeyList("a", "b")
+	for _, key := range keys.keys {
+		key.limiter = rate.NewLimiter(rate.Inf, 1)
+	}
+
+	keys.quarantine(keys.keys[0], time.Now(), "test")
+	keys.Throttle(keys.keys[1], 100*time.Millisecond)
+
+	start := time.Now()
+	key, err := keys.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Could not acquire key: %v", err)
+	}
+	assert.Equal(t, "b", key.key, "The throttled key is used once it recovers")
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+	keys.Release(key, 200, 0, nil)
+
+	keys.quarantine(keys.keys[1], time.Now(), "test")
+	_, err = keys.Acquire(context.Background())
+	assert.ErrorIs(t, err, ErrNoKeys)
+}
+
+func TestKeyListQuarantinesFailingKeys(t *testing.T) {
+	t.Parallel()
+	keys := makeTestKeyList("a", "b")
+	for _, key := range keys.keys {
+		key.limiter = rate.NewLimiter(rate.Inf, 1)
+	}
+	a, b := keys.keys[0], keys.keys[1]
+
+	release := func(key *apiKey, status int, err error) {
+		if !keys.Reserve(key) {
+			t.Fatalf("Could not reserve key %s", key.key)
+		}
+		keys.Release(key, status, 0, err)
+	}
+
+	release(a, http.StatusForbidden, nil)
+	release(a, http.StatusOK, nil)
+	release(a, http.StatusForbidden, nil)
+	assert.False(t, a.quarantined, "Denials are only counted in a row")
+	release(a, http.StatusForbidden, nil)
+	assert.True(t, a.quarantined)
+	assert.Equal(t, 1, keys.Healthy())
+
+	invalidIp := &coc.APIError{StatusCode: http.StatusForbidden, Reason: coc.ReasonInvalidIp}
+	release(b, http.StatusForbidden, invalidIp)
+	assert.True(t, b.quarantined, "Keys not allowed from this ip are quarantined right away")
+	assert.Equal(t, 0, keys.Healthy())
+	_, err := keys.Acquire(context.Background())
+	assert.ErrorIs(t, err, ErrNoKeys)
+
+	assert.Empty(t, keys.DueForProbe())
+	time.Sleep(60 * time.Millisecond)
+	assert.ElementsMatch(t, []*apiKey{a, b}, keys.DueForProbe())
+
+	// A failed probe doubles the cool-down, up to the maximum
+	release(b, http.StatusForbidden, invalidIp)
+	assert.Equal(t, 100*time.Millisecond, b.cooldown)
+	release(b, http.StatusForbidden, invalidIp)
+	assert.Equal(t, 100*time.Millisecond, b.cooldown)
+
+	release(a, http.StatusServiceUnavailable, &coc.APIError{StatusCode: http.StatusServiceUnavailable})
+	release(a, http.StatusNotFound, &coc.APIError{StatusCode: http.StatusNotFound})
+	assert.True(t, a.quarantined, "Errors that say nothing about the key don't end the quarantine")
+	release(a, http.StatusOK, nil)
+	assert.False(t, a.quarantined, "A successful probe ends the quarantine")
+	key, err := keys.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Could not acquire key: %v", err)
+	}
+	assert.Equal(t, "a", key.key)
+	keys.Release(key, http.StatusOK, 0, nil)
+
+	health := keys.Health()
+	assert.Equal(t, KeyHealthy, health[0].State)
+	assert.Equal(t, 3, health[0].Successes)
+	assert.Equal(t, 3, health[0].AccessDenied)
+	assert.Equal(t, KeyQuarantined, health[1].State)
+	assert.Equal(t, 3, health[1].InvalidIp)
+	assert.NotNil(t, health[1].Until)
+}
+
+func TestKeyListAdaptsRates(t *testing.T) {
+	t.Parallel()
+	keys := &KeyList{keys: []*apiKey{newAPIKey("a", keysFileSource, KeySchedulerConfiguration{
+		Rate:         8,
+		MaxRate:      10,
+		MinRate:      1,
+		Burst:        100,
+		RateIncrease: 1,
+	})}}
+	key := keys.keys[0]
+
+	use := func(status int) {
+		acquired, err := keys.Acquire(context.Background())
+		if err != nil {
+			t.Fatalf("Could not acquire key: %v", err)
+		}
+		keys.Release(acquired, status, 10*time.Millisecond, nil)
+	}
+
+	use(200)
+	assert.Equal(t, rate.Limit(9), key.limiter.Limit())
+	use(404)
+	assert.Equal(t, rate.Limit(9), key.limiter.Limit(), "Errors that are not throttling don't change the rate")
+	use(200)
+	use(200)
+	assert.Equal(t, rate.Limit(10), key.limiter.Limit(), "The rate never goes over the maximum")
+
+	for i := 0; i < 5; i++ {
+		use(429)
+	}
+	assert.Equal(t, rate.Limit(1), key.limiter.Limit(), "The rate never goes under the minimum")
+	assert.Equal(t, 5, key.throttles)
+	assert.Equal(t, 10*time.Millisecond, key.latency)
+}
+
+// simulateRequests makes the given number of requests from several goroutines,
+// each holding its key for the given time, and returns how many used every key.
+func simulateRequests(t *testing.T, keys *KeyList, requests int, hold func(key *apiKey) time.Duration) map[string]int {
+	t.Helper()
+	var mu sync.Mutex
+	used := make(map[string]int)
+	var wg sync.WaitGroup
+	queue := make(chan struct{}, requests)
+	for i := 0; i < requests; i++ {
+		queue <- struct{}{}
+	}
+	close(queue)
+
+	for worker := 0; worker < 16; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range queue {
+				key, err := keys.Acquire(context.Background())
+				if err != nil {
+					t.Errorf("Could not acquire key: %v", err)
+					return
+				}
+				latency := hold(key)
+				time.Sleep(latency)
+				keys.Release(key, 200, latency, nil)
+				mu.Lock()
+				used[key.key]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return used
+}
+
+func TestKeyListDistributesLoad(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Evenly between equal keys", func(t *testing.T) {
+		t.Parallel()
+		keys := &KeyList{}
+		for _, name := range []string{"a", "b", "c", "d"} {
+			keys.keys = append(keys.keys, newAPIKey(name, keysFileSource, KeySchedulerConfiguration{Rate: 500, MaxRate: 500, MinRate: 500, Burst: 5}))
+		}
+
+		used := simulateRequests(t, keys, 400, func(*apiKey) time.Duration { return time.Millisecond })
+		for _, name := range []string{"a", "b", "c", "d"} {
+			assert.InDelta(t, 100, used[name], 25, "Requests made with key %s: %v", name, used)
+		}
+		for _, key := range keys.keys {
+			assert.Equal(t, 0, key.inFlight)
+		}
+	})
+
+	t.Run("Proportionally to the rate of each key", func(t *testing.T) {
+		t.Parallel()
+		keys := &KeyList{keys: []*apiKey{
+			newAPIKey("slow", keysFileSource, KeySchedulerConfiguration{Rate: 100, MaxRate: 100, MinRate: 100, Burst: 1}),
+			newAPIKey("fast", keysFileSource, KeySchedulerConfiguration{Rate: 300, MaxRate: 300, MinRate: 300, Burst: 1}),
+		}}
+
+		used := simulateRequests(t, keys, 200, func(*apiKey) time.Duration { return 0 })
+		ratio := float64(used["fast"]) / float64(used["slow"])
+		assert.InDelta(t, 3, ratio, 1, "Requests made with every key: %v", used)
+	})
+
+	t.Run("Away from slow keys", func(t *testing.T) {
+		t.Parallel()
+		keys := &KeyList{}
+		for _, name := range []string{"slow", "fast"} {
+			keys.keys = append(keys.keys, newAPIKey(name, keysFileSource, KeySchedulerConfiguration{Rate: 1000, MaxRate: 1000, MinRate: 1000, Burst: 20}))
+		}
+
+		used := simulateRequests(t, keys, 200, func(key *apiKey) time.Duration {
+			if key.key == "slow" {
+				return 20 * time.Millisecond
+			}
+			return time.Millisecond
+		})
+		assert.Greater(t, used["fast"], 2*used["slow"], "Requests made with every key: %v", used)
+	})
+}
+
+func TestKeyListReplace(t *testing.T) {
+	t.Parallel()
+	conf := KeySchedulerConfiguration{Rate: 1, MaxRate: 1, MinRate: 1, Burst: 2, RateIncrease: 1}
+	keys := &KeyList{}
+	keys.Replace(keysFileSource, tokenSpecs("a", "b"), conf)
+	keys.Replace(portalKeysSource, tokenSpecs("portal", "b"), conf)
+	assert.Equal(t, 3, keys.Len(), "Keys loaded by another source are skipped")
+
+	kept := keys.keys[0]
+	kept.successes = 5
+	removedKey := keys.keys[1]
+	removedKey.limiter = rate.NewLimiter(rate.Inf, 1)
+	removedKey.inFlight = 1
+
+	added, removed := keys.Replace(keysFileSource, tokenSpecs("a", "c"), conf)
+	assert.Equal(t, []string{"c"}, added)
+	assert.Equal(t, []*apiKey{removedKey}, removed)
+	assert.Same(t, kept, keys.keys[0], "Unchanged keys keep their state")
+	assert.Equal(t, 5, keys.keys[0].successes)
+
+	for i := 0; i < 3; i++ {
+		key, err := keys.Acquire(context.Background())
+		if err != nil {
+			t.Fatalf("Could not acquire key: %v", err)
+		}
+		assert.NotEqual(t, "b", key.key, "Removed keys are not handed out")
+		keys.Release(key, 200, 0, nil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, keys.Drain(ctx, removed), context.DeadlineExceeded, "The removed key still has a request in flight")
+
+	keys.Release(removedKey, 200, 0, nil)
+	assert.NoError(t, keys.Drain(context.Background(), removed))
+}
+
+func TestKeyListReplaceAppliesSpecs(t *testing.T) {
+	t.Parallel()
+	conf := DefaultKeySchedulerConfiguration()
+	keys := &KeyList{}
+	keys.Replace(keysFileSource, []KeySpec{
+		{Token: "a", Label: "main", Rate: 5, Burst: 3, Enabled: true},
+		{Token: "b", Kinds: []string{"update/*"}, Enabled: true},
+		{Token: "c", Enabled: false},
+	}, conf)
+
+	assert.Equal(t, 2, keys.Len(), "Disabled keys are not loaded")
+	a, b := keys.keys[0], keys.keys[1]
+	assert.Equal(t, "main (...)", a.Name())
+	assert.Equal(t, rate.Limit(5), a.limiter.Limit())
+	assert.Equal(t, 3, a.limiter.Burst())
+	assert.Equal(t, rate.Limit(5), a.maxRate)
+	assert.True(t, b.serves("update/FetchCapitalLeagues"))
+	assert.False(t, b.serves("cleanup/Runs"))
+	assert.True(t, b.serves(""), "Requests not made by jobs can use any key")
+
+	a.limiter.SetLimit(2)
+	keys.Replace(keysFileSource, []KeySpec{
+		{Token: "a", Label: "renamed", Rate: 5, Burst: 3, Enabled: true},
+		{Token: "b", Rate: 10, Enabled: true},
+	}, conf)
+	assert.Same(t, a, keys.keys[0])
+	assert.Equal(t, rate.Limit(2), a.limiter.Limit(), "The limiter is kept while the rate doesn't change")
+	assert.Equal(t, "renamed (...)", a.Name())
+	assert.Equal(t, rate.Limit(10), b.limiter.Limit())
+	assert.True(t, b.serves("cleanup/Runs"))
+}
+
+func TestKeyListProbesOfRemovedKeys(t *testing.T) {
+	t.Parallel()
+	conf := DefaultKeySchedulerConfiguration()
+	keys := &KeyList{}
+	keys.Replace(keysFileSource, tokenSpecs("a", "b"), conf)
+	removedKey := keys.keys[0]
+	keys.quarantine(removedKey, time.Now(), "test")
+
+	// The key is removed between finding it due for a probe and probing it
+	_, removed := keys.Replace(keysFileSource, tokenSpecs("b"), conf)
+	assert.Equal(t, []*apiKey{removedKey}, removed)
+	assert.False(t, keys.Reserve(removedKey), "Removed keys are not probed")
+	assert.NoError(t, keys.Drain(context.Background(), removed))
+
+	// A request that was already in flight finishing after the key was drained
+	removedKey.inFlight = 1
+	assert.NotPanics(t, func() { keys.Release(removedKey, http.StatusOK, 0, nil) })
+}
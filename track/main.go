@@ -1,6 +1,9 @@
 package track
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/MrNemo64/coc-tracker/util"
 )
 
@@ -9,6 +12,20 @@ func init() {
 }
 
 func Run() {
-	client := CreateCocClient()
-	client.Run()
+	if len(os.Args) < 2 || os.Args[1] == "run" {
+		client := CreateCocClient()
+		client.Run()
+		return
+	}
+
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %s\n", os.Args[1])
+		os.Exit(2)
+	}
+
+	if err := command(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error running %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
 }
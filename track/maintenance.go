@@ -0,0 +1,64 @@
+package track
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/MrNemo64/coc-tracker/util"
+)
+
+const (
+	// How long a throttled key is avoided when the API does not say
+	defaultThrottleBackoff = 10 * time.Second
+	// How often the API is checked while it is in maintenance
+	maintenanceProbeInterval = time.Minute
+)
+
+// enterMaintenance holds the jobs of this instance until the API is out of
+// maintenance, instead of letting every job fail and retry meanwhile.
+func (c *CocClient) enterMaintenance() {
+	if !c.inMaintenance.CompareAndSwap(false, true) {
+		return
+	}
+	c.logger.Warn("API in maintenance, holding jobs until it recovers", "probe-interval", maintenanceProbeInterval)
+	c.jobs.Hold()
+	go c.probeMaintenance()
+}
+
+func (c *CocClient) probeMaintenance() {
+	ticker := time.NewTicker(maintenanceProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !c.probe() {
+			continue
+		}
+		c.logger.Info("API out of maintenance, resuming jobs")
+		if err := c.jobs.Unhold(c.db); err != nil {
+			c.logger.Warn("Error notifying jobs after maintenance, waiting for the next poll", "err", err)
+		}
+		c.inMaintenance.Store(false)
+		return
+	}
+}
+
+// probe requests a cheap endpoint, bypassing the cache, and reports whether
+// the API is available again.
+func (c *CocClient) probe() bool {
+	request, err := http.NewRequestWithContext(c.ctx, http.MethodGet, util.BaseUrl+util.GoldpassEndpoint, nil)
+	if err != nil {
+		return false
+	}
+	response, err := c.send(request)
+	if err != nil {
+		c.logger.Warn("Error probing API maintenance", "err", err)
+		return false
+	}
+	response.Body.Close()
+	return response.StatusCode != http.StatusServiceUnavailable
+}
@@ -0,0 +1,262 @@
+package portal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MrNemo64/coc-tracker/util"
+)
+
+// Keys an account of the developer portal can have at once
+const accountKeyLimit = 10
+
+// How long a request to the portal or the public IP service can take
+const requestTimeout = 30 * time.Second
+
+type Configuration struct {
+	Email    string
+	Password string
+	// Name of the keys created by the manager. Keys with other names are never
+	// revoked, so keys created by hand can live in the same account.
+	KeyName string
+	// How many keys the manager keeps for the current IP, at most the account limit
+	KeyCount int
+	BaseUrl  string
+	IPUrl    string
+}
+
+func DefaultConfiguration() Configuration {
+	return Configuration{
+		KeyName:  "coc-tracker",
+		KeyCount: accountKeyLimit,
+		BaseUrl:  util.DevBaseUrl,
+		IPUrl:    util.IPUrl,
+	}
+}
+
+// ConfigurationFromEnv returns false if no developer portal account is set up.
+func ConfigurationFromEnv() (Configuration, bool) {
+	conf := DefaultConfiguration()
+	conf.Email = os.Getenv("DEV_EMAIL")
+	conf.Password = os.Getenv("DEV_PASSWORD")
+	if conf.Email == "" || conf.Password == "" {
+		return conf, false
+	}
+	if name := os.Getenv("DEV_KEY_NAME"); name != "" {
+		conf.KeyName = name
+	}
+	if count := os.Getenv("DEV_KEY_COUNT"); count != "" {
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			panic(err)
+		}
+		if n < 1 || n > accountKeyLimit {
+			panic(fmt.Errorf("DEV_KEY_COUNT must be between 1 and %d, got %d", accountKeyLimit, n))
+		}
+		conf.KeyCount = n
+	}
+	return conf, true
+}
+
+type Key struct {
+	Id          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	CidrRanges  []string `json:"cidrRanges"`
+	Scopes      []string `json:"scopes"`
+	Key         string   `json:"key"`
+}
+
+// allows reports whether the key can be used from the IP.
+func (k *Key) allows(ip netip.Addr) bool {
+	for _, cidr := range k.CidrRanges {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			if addr, err := netip.ParseAddr(cidr); err == nil && addr == ip {
+				return true
+			}
+			continue
+		}
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Manager keeps the keys of a developer portal account usable from the public
+// IP of this machine.
+type Manager struct {
+	conf   Configuration
+	client *http.Client
+	logger *slog.Logger
+}
+
+func NewManager(conf Configuration, logger *slog.Logger) *Manager {
+	// The portal authenticates with the session cookie set on login
+	jar, _ := cookiejar.New(nil)
+	return &Manager{
+		conf:   conf,
+		client: &http.Client{Jar: jar, Timeout: requestTimeout},
+		logger: logger,
+	}
+}
+
+// PublicIP asks an external service for the IP the API sees requests from.
+func (m *Manager) PublicIP(ctx context.Context) (netip.Addr, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, m.conf.IPUrl, nil)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	response, err := m.client.Do(request)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return netip.Addr{}, fmt.Errorf("unexpected status looking up public ip: %s", response.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, 256))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return netip.ParseAddr(strings.TrimSpace(string(body)))
+}
+
+func (m *Manager) Login(ctx context.Context) error {
+	return m.post(ctx, util.LoginEndpoint, map[string]string{
+		"email":    m.conf.Email,
+		"password": m.conf.Password,
+	}, nil)
+}
+
+func (m *Manager) ListKeys(ctx context.Context) ([]Key, error) {
+	var response struct {
+		Keys []Key `json:"keys"`
+	}
+	if err := m.post(ctx, util.KeyListEndpoint, struct{}{}, &response); err != nil {
+		return nil, err
+	}
+	return response.Keys, nil
+}
+
+func (m *Manager) CreateKey(ctx context.Context, ip netip.Addr) (Key, error) {
+	var response struct {
+		Key Key `json:"key"`
+	}
+	err := m.post(ctx, util.KeyCreateEndpoint, map[string]any{
+		"name":        m.conf.KeyName,
+		"description": "Created for " + ip.String(),
+		"cidrRanges":  []string{ip.String()},
+		"scopes":      []string{"clash"},
+	}, &response)
+	return response.Key, err
+}
+
+func (m *Manager) RevokeKey(ctx context.Context, id string) error {
+	return m.post(ctx, util.KeyRevokeEndpoint, map[string]string{"id": id}, nil)
+}
+
+// EnsureKeys logs into the portal and makes sure the account has the configured
+// number of keys usable from the current IP: keys of the manager bound to other
+// IPs are revoked and new ones are created while there is room in the account.
+// Returns the tokens of the keys usable from the current IP.
+func (m *Manager) EnsureKeys(ctx context.Context) ([]string, error) {
+	ip, err := m.PublicIP(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up public ip: %w", err)
+	}
+	if err := m.Login(ctx); err != nil {
+		return nil, fmt.Errorf("error logging into the developer portal: %w", err)
+	}
+	keys, err := m.ListKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing keys: %w", err)
+	}
+
+	var usable []string
+	total := len(keys)
+	for _, key := range keys {
+		if key.allows(ip) {
+			usable = append(usable, key.Key)
+			continue
+		}
+		if key.Name != m.conf.KeyName {
+			continue
+		}
+		m.logger.Info("Revoking key bound to another ip", "key-id", key.Id, "cidr-ranges", key.CidrRanges)
+		if err := m.RevokeKey(ctx, key.Id); err != nil {
+			return nil, fmt.Errorf("error revoking key %s: %w", key.Id, err)
+		}
+		total--
+	}
+
+	for len(usable) < m.conf.KeyCount && total < accountKeyLimit {
+		key, err := m.CreateKey(ctx, ip)
+		if err != nil {
+			return nil, fmt.Errorf("error creating key: %w", err)
+		}
+		m.logger.Info("Created key for the current ip", "key-id", key.Id, "ip", ip)
+		usable = append(usable, key.Key)
+		total++
+	}
+	if len(usable) < m.conf.KeyCount {
+		m.logger.Warn("The account has no room for more keys", "keys", len(usable), "wanted", m.conf.KeyCount)
+	}
+	return usable, nil
+}
+
+// post sends a request to the portal, which answers every endpoint with a status
+// object that tells whether the request worked.
+func (m *Manager) post(ctx context.Context, endpoint string, body any, result any) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, m.conf.BaseUrl+endpoint, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+
+	response, err := m.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	var status struct {
+		Status struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"status"`
+	}
+	json.Unmarshal(content, &status)
+	if response.StatusCode != http.StatusOK || status.Status.Code != 0 {
+		message := status.Status.Message
+		if message == "" {
+			message = response.Status
+		}
+		return fmt.Errorf("developer portal rejected %s: %s", endpoint, message)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(content, result)
+}
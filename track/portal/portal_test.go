@@ -0,0 +1,134 @@
+package portal_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	testutil "github.com/MrNemo64/coc-tracker/test_util"
+	"github.com/MrNemo64/coc-tracker/track/portal"
+	"github.com/MrNemo64/coc-tracker/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePortal stands in for the developer portal and the public IP service.
+type fakePortal struct {
+	mu      sync.Mutex
+	ip      string
+	keys    []portal.Key
+	nextId  int
+	revoked []string
+}
+
+func (f *fakePortal) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ip", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, f.ip)
+	})
+	mux.HandleFunc("POST "+util.LoginEndpoint, func(w http.ResponseWriter, r *http.Request) {
+		var credentials map[string]string
+		json.NewDecoder(r.Body).Decode(&credentials)
+		if credentials["email"] != "dev@example.com" || credentials["password"] != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]any{"status": map[string]any{"code": 403, "message": "invalid credentials"}})
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "logged-in", Path: "/"})
+		respond(w, map[string]any{})
+	})
+	authenticated := func(handle func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "logged-in" {
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]any{"status": map[string]any{"code": 403, "message": "not logged in"}})
+				return
+			}
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			handle(w, r)
+		}
+	}
+	mux.HandleFunc("POST "+util.KeyListEndpoint, authenticated(func(w http.ResponseWriter, r *http.Request) {
+		respond(w, map[string]any{"keys": f.keys})
+	}))
+	mux.HandleFunc("POST "+util.KeyCreateEndpoint, authenticated(func(w http.ResponseWriter, r *http.Request) {
+		if len(f.keys) >= 10 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]any{"status": map[string]any{"code": 400, "message": "too many keys"}})
+			return
+		}
+		var key portal.Key
+		json.NewDecoder(r.Body).Decode(&key)
+		f.nextId++
+		key.Id = fmt.Sprint(f.nextId)
+		key.Key = "token-" + key.Id
+		f.keys = append(f.keys, key)
+		respond(w, map[string]any{"key": key})
+	}))
+	mux.HandleFunc("POST "+util.KeyRevokeEndpoint, authenticated(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		for i, key := range f.keys {
+			if key.Id == body["id"] {
+				f.keys = append(f.keys[:i], f.keys[i+1:]...)
+				f.revoked = append(f.revoked, key.Id)
+				break
+			}
+		}
+		respond(w, map[string]any{})
+	}))
+	return mux
+}
+
+func respond(w http.ResponseWriter, body map[string]any) {
+	body["status"] = map[string]any{"code": 0, "message": "ok"}
+	json.NewEncoder(w).Encode(body)
+}
+
+func TestEnsureKeys(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakePortal{
+		ip:     "203.0.113.7",
+		nextId: 100,
+		keys: []portal.Key{
+			{Id: "1", Name: "coc-tracker", CidrRanges: []string{"198.51.100.1"}, Key: "stale"},
+			{Id: "2", Name: "coc-tracker", CidrRanges: []string{"203.0.113.7"}, Key: "current"},
+			{Id: "3", Name: "by-hand", CidrRanges: []string{"198.51.100.1"}, Key: "by-hand"},
+			{Id: "4", Name: "by-hand", CidrRanges: []string{"203.0.113.0/24"}, Key: "range"},
+		},
+	}
+	server := httptest.NewServer(fake.handler())
+	t.Cleanup(server.Close)
+
+	conf := portal.DefaultConfiguration()
+	conf.Email = "dev@example.com"
+	conf.Password = "secret"
+	conf.KeyCount = 4
+	conf.BaseUrl = server.URL
+	conf.IPUrl = server.URL + "/ip"
+	manager := portal.NewManager(conf, testutil.MakeTestLogger().Logger)
+
+	tokens, err := manager.EnsureKeys(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"current", "range", "token-101", "token-102"}, tokens)
+	assert.Equal(t, []string{"1"}, fake.revoked, "Only stale keys of the manager are revoked")
+
+	// Once the ip changes every key of the manager is replaced, up to the account limit
+	fake.ip = "192.0.2.1"
+	conf.KeyCount = 10
+	manager = portal.NewManager(conf, testutil.MakeTestLogger().Logger)
+	tokens, err = manager.EnsureKeys(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, tokens, 8)
+	assert.ElementsMatch(t, []string{"1", "2", "101", "102"}, fake.revoked)
+	assert.Len(t, fake.keys, 10)
+
+	conf.Password = "wrong"
+	_, err = portal.NewManager(conf, testutil.MakeTestLogger().Logger).EnsureKeys(context.Background())
+	assert.ErrorContains(t, err, "invalid credentials")
+}
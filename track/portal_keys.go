@@ -0,0 +1,46 @@
+package track
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/MrNemo64/coc-tracker/track/portal"
+)
+
+// Source of the keys created through the developer portal
+const portalKeysSource = "portal"
+
+// How long getting keys from the developer portal can take
+const portalTimeout = 2 * time.Minute
+
+// createKeyPortal returns nil unless a developer portal account is set up.
+func createKeyPortal(logger *slog.Logger) *portal.Manager {
+	conf, ok := portal.ConfigurationFromEnv()
+	if !ok {
+		return nil
+	}
+	return portal.NewManager(conf, logger.With("name", "portal"))
+}
+
+// refreshPortalKeys gets keys for the current IP from the developer portal in
+// the background, as a key being rejected for its IP usually means the IP of
+// the machine changed.
+func (c *CocClient) refreshPortalKeys() {
+	if c.keyPortal == nil || !c.refreshingKeys.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer c.refreshingKeys.Store(false)
+		ctx, cancel := context.WithTimeout(c.ctx, portalTimeout)
+		defer cancel()
+		tokens, err := c.keyPortal.EnsureKeys(ctx)
+		if err != nil {
+			c.logger.Error("Error refreshing keys from the developer portal", "err", err)
+			return
+		}
+		// Removed keys were revoked, their requests fail anyway so they are not drained
+		added, removed := c.keys.Replace(portalKeysSource, tokenSpecs(tokens...), c.keyConf)
+		c.logger.Info("Refreshed keys from the developer portal", "added", len(added), "removed", len(removed))
+	}()
+}